@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Seann-Moser/wp/source_code"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+func fetchFlags() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("fetch", pflag.ContinueOnError)
+	fs.StringSlice("getters", []string{"direct", "flaresolver", "zenrows"}, "Getters to try, in order: direct, flaresolver, zenrows or browser")
+	fs.Duration("timeout", 2*time.Minute, "Maximum time per URL across every getter")
+	fs.String("method", http.MethodGet, "HTTP method")
+	fs.StringArray("header", nil, `Request header as "Key: Value", may be repeated`)
+	fs.String("user-agent", "", "User agent to send")
+	fs.String("output-dir", "", "Write each body to a file in this directory instead of stdout")
+	fs.Bool("jsonl", false, "Print one JSON object per URL instead of the body")
+	fs.AddFlagSet(source_code.DirectFlags())
+	fs.AddFlagSet(source_code.FlareSolverFlags())
+	fs.AddFlagSet(source_code.ZenRowFlags())
+	fs.AddFlagSet(source_code.BrowserFlags())
+	fs.AddFlagSet(source_code.BackOffFlags("fetch"))
+	return fs
+}
+
+// fetchResult is what fetch reports for one URL, and the shape of each JSON line.
+type fetchResult struct {
+	URL        string      `json:"url"`
+	FinalURL   string      `json:"final_url,omitempty"`
+	StatusCode int         `json:"status_code"`
+	Getter     string      `json:"getter,omitempty"`
+	DurationMS int64       `json:"duration_ms"`
+	Bytes      int         `json:"bytes"`
+	File       string      `json:"file,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	Challenges []challenge `json:"challenges,omitempty"`
+	Attempts   []attempt   `json:"attempts,omitempty"`
+	Error      string      `json:"error,omitempty"`
+
+	mediaType string
+}
+
+type challenge struct {
+	Getter     string  `json:"getter"`
+	Vendor     string  `json:"vendor"`
+	Kind       string  `json:"kind"`
+	Confidence float64 `json:"confidence"`
+	Signal     string  `json:"signal"`
+}
+
+type attempt struct {
+	Getter     string `json:"getter"`
+	StatusCode int    `json:"status_code,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+	Skipped    bool   `json:"skipped,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+func fetch(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := fetchFlags()
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: wp fetch [flags] URL...\n\n%s", fs.FlagUsages())
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	viper.AutomaticEnv()
+	if err := viper.BindPFlags(fs); err != nil {
+		fmt.Fprintf(stderr, "wp: %v\n", err)
+		return 1
+	}
+
+	getter, err := newFallback(http.DefaultClient, viper.GetStringSlice("getters"))
+	if err != nil {
+		fmt.Fprintf(stderr, "wp: %v\n", err)
+		return 2
+	}
+	options, err := fetchOptions()
+	if err != nil {
+		fmt.Fprintf(stderr, "wp: %v\n", err)
+		return 2
+	}
+	outputDir := viper.GetString("output-dir")
+	if outputDir != "" {
+		if err = os.MkdirAll(outputDir, 0o755); err != nil {
+			fmt.Fprintf(stderr, "wp: %v\n", err)
+			return 1
+		}
+	}
+
+	code := 0
+	encoder := json.NewEncoder(stdout)
+	for _, endpoint := range fs.Args() {
+		result := fetchOne(ctx, getter, endpoint, options)
+		if result.Error != "" {
+			code = 1
+		}
+		if outputDir != "" && result.Error == "" {
+			if err = result.writeFile(outputDir); err != nil {
+				result.Error = err.Error()
+				code = 1
+			}
+		}
+		if viper.GetBool("jsonl") {
+			if err = encoder.Encode(result); err != nil {
+				fmt.Fprintf(stderr, "wp: %v\n", err)
+				return 1
+			}
+			continue
+		}
+		result.print(stdout, stderr)
+	}
+	if err = flushCookies(getter); err != nil {
+		fmt.Fprintf(stderr, "wp: %v\n", err)
+		return 1
+	}
+	return code
+}
+
+// newFallback builds the named getters from their flags, each wrapped to record its attempts.
+func newFallback(client *http.Client, names []string) (*source_code.Fallback, error) {
+	var getters []source_code.SourceGetter
+	for _, name := range names {
+		var getter source_code.SourceGetter
+		switch strings.TrimSpace(strings.ToLower(name)) {
+		case "direct":
+			d, err := source_code.NewDirectFromFlags(client)
+			if err != nil {
+				return nil, err
+			}
+			getter = d
+		case "flaresolver":
+			f, err := source_code.NewFlareSolverFromFlags(client)
+			if err != nil {
+				return nil, err
+			}
+			getter = f
+		case "zenrows":
+			z := source_code.NewZenRowsFromFlags(client)
+			if err := z.Err(); err != nil {
+				return nil, err
+			}
+			getter = z
+		case "browser":
+			getter = source_code.NewBrowserFromFlags(client)
+		default:
+			return nil, fmt.Errorf("unknown getter %q", name)
+		}
+		getters = append(getters, source_code.Chain(getter, recordAttempts()))
+	}
+	if len(getters) == 0 {
+		return nil, source_code.NoFallbackSourceErr
+	}
+	return source_code.NewFallback(getters...), nil
+}
+
+// flushCookies saves the cookie jar of any Direct getter, which would otherwise
+// be lost when the process exits before the jar's SaveDelay.
+func flushCookies(f *source_code.Fallback) error {
+	var errs []error
+	for _, getter := range f.Getters {
+		for getter != nil {
+			if d, ok := getter.(*source_code.Direct); ok {
+				if jar, ok := d.Jar.(*source_code.HostCookieJar); ok {
+					errs = append(errs, jar.Flush())
+				}
+				break
+			}
+			wrapper, ok := getter.(interface {
+				Unwrap() source_code.SourceGetter
+			})
+			if !ok {
+				break
+			}
+			getter = wrapper.Unwrap()
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func fetchOptions() (source_code.SourceOptions, error) {
+	o := source_code.SourceOptions{
+		MaxDuration: viper.GetDuration("timeout"),
+		Method:      strings.ToUpper(viper.GetString("method")),
+		UserAgent:   viper.GetString("user-agent"),
+	}
+	if viper.GetUint64(source_code.GetFlagWithPrefix("max-retry", "fetch")) > 0 {
+		o.BackOff = source_code.NewBackoffWithFlags("fetch")
+	}
+	for _, header := range viper.GetStringSlice("header") {
+		key, value, found := strings.Cut(header, ":")
+		if !found {
+			return o, fmt.Errorf(`invalid header %q, expected "Key: Value"`, header)
+		}
+		if o.Headers == nil {
+			o.Headers = http.Header{}
+		}
+		o.Headers.Add(strings.TrimSpace(key), strings.TrimSpace(value))
+	}
+	return o, nil
+}
+
+type attemptsKey struct{}
+
+// attemptLog collects the results of every getter the Fallback called for one URL.
+type attemptLog struct {
+	mu         sync.Mutex
+	attempts   []attempt
+	challenges []challenge
+}
+
+// recordAttempts adds each Get's outcome to the attemptLog in the context, so
+// challenges are reported even when a later getter succeeds.
+func recordAttempts() source_code.Middleware {
+	return source_code.WrapGet(func(next source_code.SourceGetter, get source_code.GetFunc) source_code.GetFunc {
+		return func(ctx context.Context, endpoint string, options ...source_code.SourceOptions) (*source_code.SourceResponse, error) {
+			start := time.Now()
+			resp, err := get(ctx, endpoint, options...)
+			if log, ok := ctx.Value(attemptsKey{}).(*attemptLog); ok {
+				log.add(next.Name(), resp, err, time.Since(start))
+			}
+			return resp, err
+		}
+	})
+}
+
+func (l *attemptLog) add(getter string, resp *source_code.SourceResponse, err error, duration time.Duration) {
+	a := attempt{Getter: getter, DurationMS: duration.Milliseconds()}
+	if resp != nil {
+		a.StatusCode = resp.StatusCode
+	}
+	if err != nil {
+		a.Error = err.Error()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.attempts = append(l.attempts, a)
+	var challengeErr *source_code.ChallengeError
+	if errors.As(err, &challengeErr) {
+		l.challenges = append(l.challenges, challenge{
+			Getter:     getter,
+			Vendor:     string(challengeErr.Info.Vendor),
+			Kind:       string(challengeErr.Info.Kind),
+			Confidence: challengeErr.Info.Confidence,
+			Signal:     challengeErr.Info.Signal,
+		})
+	}
+}
+
+func fetchOne(ctx context.Context, getter source_code.SourceGetter, endpoint string, options source_code.SourceOptions) fetchResult {
+	log := &attemptLog{}
+	start := time.Now()
+	resp, err := getter.Get(context.WithValue(ctx, attemptsKey{}, log), endpoint, options)
+	result := fetchResult{
+		URL:        endpoint,
+		DurationMS: time.Since(start).Milliseconds(),
+		Attempts:   log.attempts,
+		Challenges: log.challenges,
+	}
+	var fallbackErr *source_code.FallbackError
+	if errors.As(err, &fallbackErr) {
+		// The FallbackError also lists the getters that were skipped.
+		result.Attempts = result.Attempts[:0]
+		for _, a := range fallbackErr.Attempts {
+			result.Attempts = append(result.Attempts, attempt{
+				Getter:     a.Getter,
+				StatusCode: a.StatusCode,
+				DurationMS: a.Duration.Milliseconds(),
+				Skipped:    a.Skipped,
+				Error:      a.Err.Error(),
+			})
+			if !a.Skipped {
+				result.StatusCode = a.StatusCode
+				result.Getter = a.Getter
+			}
+		}
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if resp != nil {
+		result.FinalURL = resp.FinalURL
+		result.StatusCode = resp.StatusCode
+		result.Getter = resp.Getter
+		result.Bytes = len(resp.Body)
+		if err == nil {
+			result.Body = string(resp.Body)
+			result.mediaType = resp.MediaType()
+		}
+	}
+	return result
+}
+
+// writeFile moves the body into a file under dir, keeping only its path in the result.
+func (r *fetchResult) writeFile(dir string) error {
+	r.File = filepath.Join(dir, fileName(r.URL, r.mediaType))
+	if err := os.WriteFile(r.File, []byte(r.Body), 0o644); err != nil {
+		return err
+	}
+	r.Body = ""
+	return nil
+}
+
+// print writes the body to stdout and a summary of the fetch to stderr.
+func (r fetchResult) print(stdout, stderr io.Writer) {
+	status := fmt.Sprintf("%s: status %d", r.URL, r.StatusCode)
+	if r.Getter != "" {
+		status += " via " + r.Getter
+	}
+	status += fmt.Sprintf(" in %dms, %d bytes", r.DurationMS, r.Bytes)
+	if r.File != "" {
+		status += " -> " + r.File
+	}
+	fmt.Fprintln(stderr, status)
+	for _, c := range r.Challenges {
+		fmt.Fprintf(stderr, "  challenge from %s: %s %s (%s)\n", c.Getter, c.Vendor, c.Kind, c.Signal)
+	}
+	if r.Error != "" {
+		for _, a := range r.Attempts {
+			fmt.Fprintf(stderr, "  %s: status %d, %s\n", a.Getter, a.StatusCode, a.Error)
+		}
+		return
+	}
+	if r.Body != "" {
+		fmt.Fprintln(stdout, r.Body)
+	}
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// fileName turns a URL into a file name such as example.com_docs_page.html.
+func fileName(endpoint, mediaType string) string {
+	name := endpoint
+	if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+		name = u.Host + strings.TrimSuffix(u.Path, "/")
+		if u.RawQuery != "" {
+			name += "_" + u.RawQuery
+		}
+	}
+	name = strings.Trim(unsafeFileChars.ReplaceAllString(name, "_"), "_")
+	ext := ".html"
+	if mediaType != "" && mediaType != "text/html" {
+		if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+			ext = exts[0]
+		} else {
+			ext = ".body"
+		}
+	}
+	if strings.HasSuffix(name, ext) {
+		return name
+	}
+	return name + ext
+}
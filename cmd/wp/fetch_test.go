@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newSite(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+		case "/blocked":
+			w.Header().Set("cf-mitigated", "challenge")
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte("<html><title>Just a moment...</title></html>"))
+		default:
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<html>" + r.Header.Get("X-Test") + "</html>"))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFetchJSONLines(t *testing.T) {
+	server := newSite(t)
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), []string{
+		"fetch", "--getters", "direct", "--health-url-direct", server.URL + "/health",
+		"--max-retry-fetch", "0", "--header", "X-Test: hello", "--jsonl",
+		server.URL + "/page", server.URL + "/blocked",
+	}, &stdout, &stderr)
+	if code != 1 {
+		t.Fatalf("expected exit code 1 for the blocked page, got %d: %s", code, stderr.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 JSON lines, got %q", stdout.String())
+	}
+	var page, blocked fetchResult
+	if err := json.Unmarshal([]byte(lines[0]), &page); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &blocked); err != nil {
+		t.Fatal(err)
+	}
+	if page.StatusCode != http.StatusOK || page.Getter != "direct" || page.Body != "<html>hello</html>" || page.Error != "" {
+		t.Fatalf("unexpected page result %+v", page)
+	}
+	if blocked.StatusCode != http.StatusForbidden || blocked.Error == "" || len(blocked.Challenges) != 1 ||
+		blocked.Challenges[0].Vendor != "cloudflare" || blocked.Challenges[0].Getter != "direct" {
+		t.Fatalf("unexpected blocked result %+v", blocked)
+	}
+}
+
+func TestFetchOutputDir(t *testing.T) {
+	server := newSite(t)
+	dir := t.TempDir()
+	jar := filepath.Join(t.TempDir(), "cookies.json")
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), []string{
+		"fetch", "--getters", "direct", "--health-url-direct", server.URL + "/health",
+		"--direct-cookie-jar", jar, "--output-dir", dir, server.URL + "/docs/page",
+	}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr.String())
+	}
+	if stdout.Len() != 0 {
+		t.Fatalf("expected the body in a file, got %q on stdout", stdout.String())
+	}
+	path := filepath.Join(dir, fileName(server.URL+"/docs/page", "text/html"))
+	body, err := os.ReadFile(path)
+	if err != nil || string(body) != "<html></html>" {
+		t.Fatalf("unexpected file %q %v", body, err)
+	}
+	if !strings.Contains(stderr.String(), "status 200 via direct") || !strings.Contains(stderr.String(), path) {
+		t.Fatalf("unexpected summary %q", stderr.String())
+	}
+	if cookies, err := os.ReadFile(jar); err != nil || !strings.Contains(string(cookies), `"abc"`) {
+		t.Fatalf("expected the cookie jar saved before exiting, got %q %v", cookies, err)
+	}
+}
+
+func TestFileName(t *testing.T) {
+	for endpoint, want := range map[string]string{
+		"https://example.com/":               "example.com.html",
+		"https://example.com/a/b?q=1":        "example.com_a_b_q_1.html",
+		"https://example.com/logo.png":       "example.com_logo.png",
+		"https://example.com/api/items.json": "example.com_api_items.json",
+	} {
+		mediaType := "text/html"
+		switch {
+		case strings.HasSuffix(endpoint, ".png"):
+			mediaType = "image/png"
+		case strings.HasSuffix(endpoint, ".json"):
+			mediaType = "application/json"
+		}
+		if got := fileName(endpoint, mediaType); got != want {
+			t.Errorf("fileName(%q) = %q, want %q", endpoint, got, want)
+		}
+	}
+}
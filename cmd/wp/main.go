@@ -0,0 +1,43 @@
+// Command wp fetches pages through the source_code getter chain, showing which
+// getter served each one and what challenges were hit on the way.
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+)
+
+const usage = `Usage: wp <command> [flags]
+
+Commands:
+  fetch    Fetch URLs through the getter fallback chain
+
+Run "wp <command> --help" for the command's flags.
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run dispatches to a subcommand and returns the process exit code.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	switch args[0] {
+	case "fetch":
+		return fetch(ctx, args[1:], stdout, stderr)
+	case "help", "-h", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "wp: unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+}
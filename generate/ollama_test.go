@@ -8,23 +8,34 @@ import (
 	"testing"
 )
 
+// newReplayClient replays testdata/ollama.har, a synthetic fixture written by
+// hand rather than recorded, so responses and token counts are illustrative.
+func newReplayClient(t *testing.T) *http.Client {
+	replayer, err := source_code.NewReplayer("testdata/ollama.har")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Transport: replayer}
+}
+
 func TestOllama(t *testing.T) {
 	ctx := context.Background()
-	source := source_code.NewDirect(http.DefaultClient)
+	client := newReplayClient(t)
+	source := source_code.NewDirect(client)
 	source.Ping(ctx)
-	l := NewOllama(http.DefaultClient, "http://localhost:8888", OllamaModelDeepSeekCoderV2, source)
+	l := NewOllama(client, "http://localhost:8888", OllamaModelDeepSeekCoderV2, source)
 
-	err := l.GenerateParser(ctx, "")
-	if err != nil {
-		return
+	if err := l.GenerateParser(ctx, "https://github.com/Seann-Moser/"); err != nil {
+		t.Fatal(err)
 	}
 }
 
 func TestOllamaFunc(t *testing.T) {
 	ctx := context.Background()
-	source := source_code.NewDirect(http.DefaultClient)
+	client := newReplayClient(t)
+	source := source_code.NewDirect(client)
 	source.Ping(ctx)
-	l := NewOllama(http.DefaultClient, "http://localhost:8888", OllamaModelDeepSeekCoderV2, source)
+	l := NewOllama(client, "http://localhost:8888", OllamaModelDeepSeekCoderV2, source)
 
 	funList := []*ExternalFunctions{
 		{
@@ -39,11 +50,11 @@ func TestOllamaFunc(t *testing.T) {
 				},
 			},
 			Call: func(ctx context.Context, param map[string]interface{}) (interface{}, error) {
-				data, _, err := source.Get(ctx, param["url"].(string))
+				resp, err := source.Get(ctx, param["url"].(string))
 				if err != nil {
 					return nil, err
 				}
-				return string(data), nil
+				return string(resp.Body), nil
 			},
 		},
 	}
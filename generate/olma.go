@@ -10,6 +10,8 @@ import (
 	"github.com/Seann-Moser/wp/source_code"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"net/http"
 	"net/url"
 	"os"
@@ -52,6 +54,11 @@ type OllamaClient struct {
 	model                string
 	externalFunctions    []*ExternalFunctions
 	externalFunctionsMap map[string]*ExternalFunctions
+	// Metrics, when set, records token counts, generation latency and tool calls.
+	Metrics *Metrics
+	// Tracer creates the spans around FunctionCalls, generate requests and tool
+	// calls, defaulting to the global provider.
+	Tracer trace.Tracer
 }
 
 type Request struct {
@@ -65,6 +72,11 @@ type Response struct {
 	CreatedAt time.Time `json:"created_at"`
 	Response  string    `json:"response"`
 	Done      bool      `json:"done"`
+	// The counts and durations are only set on the final chunk.
+	PromptEvalCount int           `json:"prompt_eval_count,omitempty"`
+	EvalCount       int           `json:"eval_count,omitempty"`
+	TotalDuration   time.Duration `json:"total_duration,omitempty"`
+	EvalDuration    time.Duration `json:"eval_duration,omitempty"`
 }
 
 func OllamaFlags() *pflag.FlagSet {
@@ -98,11 +110,14 @@ func (o *OllamaClient) Ping(ctx context.Context) error {
 	return nil
 }
 
-func (o *OllamaClient) GenerateParser(ctx context.Context, url string) error {
-	data, _, err := o.sourceCode.Get(ctx, url)
+func (o *OllamaClient) GenerateParser(ctx context.Context, url string) (err error) {
+	ctx, span := o.startSpan(ctx, "ollama.GenerateParser", attribute.String("url.full", url))
+	defer func() { endSpan(span, err) }()
+	source, err := o.sourceCode.Get(ctx, url)
 	if err != nil {
 		return err
 	}
+	data := source.Body
 	//println(string(data))
 	u := o.hostURL + "/api/generate"
 	r := Request{
@@ -118,6 +133,7 @@ func (o *OllamaClient) GenerateParser(ctx context.Context, url string) error {
 		return err
 	}
 
+	start := time.Now()
 	resp, err := o.client.Do(req)
 	if err != nil {
 		return err
@@ -132,6 +148,9 @@ func (o *OllamaClient) GenerateParser(ctx context.Context, url string) error {
 			return err
 		}
 		text += r.Response
+		if r.Done {
+			o.observe(span, r, time.Since(start))
+		}
 	}
 	println(text)
 	return nil
@@ -157,7 +176,9 @@ func (o *OllamaClient) pullModel(ctx context.Context, model string) error {
 	}
 	return nil
 }
-func (o *OllamaClient) FunctionCalls(ctx context.Context, msg string, chatList ...Chat) ([]Chat, error) {
+func (o *OllamaClient) FunctionCalls(ctx context.Context, msg string, chatList ...Chat) (_ []Chat, err error) {
+	ctx, span := o.startSpan(ctx, "ollama.FunctionCalls", attribute.Int("wp.chat.history", len(chatList)))
+	defer func() { endSpan(span, err) }()
 	if msg == "" {
 		return nil, fmt.Errorf("empty msg")
 	}
@@ -184,6 +205,7 @@ func (o *OllamaClient) FunctionCalls(ctx context.Context, msg string, chatList .
 		return nil, err
 	}
 
+	start := time.Now()
 	resp, err := o.client.Do(req)
 	if err != nil {
 		return nil, err
@@ -200,6 +222,9 @@ func (o *OllamaClient) FunctionCalls(ctx context.Context, msg string, chatList .
 			return nil, err
 		}
 		text += r.Response
+		if r.Done {
+			o.observe(span, r, time.Since(start))
+		}
 		if r.Error != "" {
 			if strings.Contains(r.Error, "not found") {
 				err = o.pullModel(ctx, o.model)
@@ -230,7 +255,7 @@ func (o *OllamaClient) FunctionCalls(ctx context.Context, msg string, chatList .
 				output[v.Name] = v.Example
 			}
 		}
-		callResponse, err := f.Call(ctx, output)
+		callResponse, err := o.callTool(ctx, f, output)
 		if err != nil {
 			return nil, fmt.Errorf("failed to call function(%s): %w", chat.Tool.ExternalFunctions.Name, err)
 		}
@@ -256,3 +281,21 @@ func (o *OllamaClient) AddFunctions(efList ...*ExternalFunctions) {
 	}
 
 }
+
+// observe records the final chunk of a generate response on span and in Metrics.
+func (o *OllamaClient) observe(span trace.Span, last Response, duration time.Duration) {
+	span.SetAttributes(
+		attribute.Int("gen_ai.usage.input_tokens", last.PromptEvalCount),
+		attribute.Int("gen_ai.usage.output_tokens", last.EvalCount),
+	)
+	o.Metrics.generation(o.model, last, duration)
+}
+
+// callTool runs an external function in its own span.
+func (o *OllamaClient) callTool(ctx context.Context, f *ExternalFunctions, param map[string]interface{}) (_ interface{}, err error) {
+	ctx, span := o.startSpan(ctx, "ollama.tool "+f.Name, attribute.String("gen_ai.tool.name", f.Name))
+	defer func() { endSpan(span, err) }()
+	start := time.Now()
+	defer func() { o.Metrics.toolCall(f.Name, time.Since(start), err) }()
+	return f.Call(ctx, param)
+}
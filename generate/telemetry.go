@@ -0,0 +1,96 @@
+package generate
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+// TracerName is the instrumentation name spans from this package are created under.
+const TracerName = "github.com/Seann-Moser/wp/generate"
+
+// Metrics holds the Prometheus collectors for LLM calls.
+type Metrics struct {
+	Tokens       *prometheus.CounterVec
+	Generation   *prometheus.HistogramVec
+	ToolCalls    *prometheus.CounterVec
+	ToolDuration *prometheus.HistogramVec
+}
+
+// NewMetrics creates the collectors and registers them with reg, or with
+// prometheus.DefaultRegisterer when reg is nil.
+func NewMetrics(reg prometheus.Registerer) (*Metrics, error) {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	m := &Metrics{
+		Tokens: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wp_llm_tokens_total",
+			Help: "Tokens Ollama reported per model, split into prompt and completion.",
+		}, []string{"model", "type"}),
+		Generation: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "wp_llm_generation_duration_seconds",
+			Help:    "Time from sending a generate request to its last chunk.",
+			Buckets: []float64{.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+		}, []string{"model"}),
+		ToolCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wp_llm_tool_calls_total",
+			Help: "External function calls requested by the model.",
+		}, []string{"tool", "outcome"}),
+		ToolDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "wp_llm_tool_duration_seconds",
+			Help:    "External function call latency.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"tool"}),
+	}
+	for _, c := range []prometheus.Collector{m.Tokens, m.Generation, m.ToolCalls, m.ToolDuration} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// generation records a finished generate call; it is safe on a nil Metrics.
+func (m *Metrics) generation(model string, last Response, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.Tokens.WithLabelValues(model, "prompt").Add(float64(last.PromptEvalCount))
+	m.Tokens.WithLabelValues(model, "completion").Add(float64(last.EvalCount))
+	m.Generation.WithLabelValues(model).Observe(duration.Seconds())
+}
+
+func (m *Metrics) toolCall(name string, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	m.ToolCalls.WithLabelValues(name, outcome).Inc()
+	m.ToolDuration.WithLabelValues(name).Observe(duration.Seconds())
+}
+
+func (o *OllamaClient) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	tracer := o.Tracer
+	if tracer == nil {
+		tracer = otel.Tracer(TracerName)
+	}
+	attrs = append(attrs, attribute.String("gen_ai.request.model", o.model))
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records err on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
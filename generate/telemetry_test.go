@@ -0,0 +1,61 @@
+package generate
+
+import (
+	"context"
+	"github.com/Seann-Moser/wp/source_code"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+)
+
+func TestOllamaTelemetry(t *testing.T) {
+	ctx := context.Background()
+	client := newReplayClient(t)
+	source := source_code.NewDirect(client)
+	source.Ping(ctx)
+	metrics, err := NewMetrics(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	exporter := tracetest.NewInMemoryExporter()
+	l := NewOllama(client, "http://localhost:8888", OllamaModelDeepSeekCoderV2, source)
+	l.Metrics = metrics
+	l.Tracer = sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)).Tracer(TracerName)
+	l.AddFunctions(&ExternalFunctions{
+		Name: "GetURLSourceCode",
+		Call: func(ctx context.Context, param map[string]interface{}) (interface{}, error) {
+			resp, err := source.Get(ctx, param["url"].(string))
+			if err != nil {
+				return nil, err
+			}
+			return string(resp.Body), nil
+		},
+	})
+
+	if _, err = l.FunctionCalls(ctx, "tell me about this website: https://github.com/Seann-Moser/"); err != nil {
+		t.Fatal(err)
+	}
+	// The counts come from the synthetic fixture's final streamed line.
+	if n := testutil.ToFloat64(metrics.Tokens.WithLabelValues(OllamaModelDeepSeekCoderV2, "prompt")); n != 412 {
+		t.Fatalf("expected 412 prompt tokens, got %v", n)
+	}
+	if n := testutil.ToFloat64(metrics.Tokens.WithLabelValues(OllamaModelDeepSeekCoderV2, "completion")); n != 96 {
+		t.Fatalf("expected 96 completion tokens, got %v", n)
+	}
+	if n := testutil.ToFloat64(metrics.ToolCalls.WithLabelValues("GetURLSourceCode", "success")); n != 1 {
+		t.Fatalf("expected 1 tool call, got %v", n)
+	}
+	if n := testutil.CollectAndCount(metrics.Generation); n != 1 {
+		t.Fatalf("expected a generation latency, got %d", n)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 || spans[0].Name != "ollama.tool GetURLSourceCode" || spans[1].Name != "ollama.FunctionCalls" {
+		t.Fatalf("unexpected spans %v", spans.Snapshots())
+	}
+	if spans[0].Parent.SpanID() != spans[1].SpanContext.SpanID() {
+		t.Fatalf("expected the tool span under FunctionCalls")
+	}
+}
@@ -41,22 +41,32 @@ func NewBackoffWithFlags(prefix string) *BackOff {
 		initialInterval: viper.GetDuration(GetFlagWithPrefix("max-initial-interval", prefix)),
 	}
 }
+
+func NewBackOff(maxRetry uint64, initialInterval, maxInterval, maxElapsedTime time.Duration) *BackOff {
+	return &BackOff{
+		maxRetry:        maxRetry,
+		maxInterval:     maxInterval,
+		maxElapsedTime:  maxElapsedTime,
+		initialInterval: initialInterval,
+	}
+}
+
 func (b *BackOff) Retry(ctx context.Context, operation bf.Operation) error {
 	notify := func(err error, backoffDuration time.Duration) {
 		logc.Warn(ctx, "retrying", zap.Error(err), zap.Duration("backoff_duration", backoffDuration))
 	}
-	if err := bf.RetryNotify(operation, b.getBackoff(), notify); err != nil {
+	if err := bf.RetryNotify(operation, b.getBackoff(ctx), notify); err != nil {
 		return err
 	}
 	return nil
 
 }
-func (b *BackOff) getBackoff() bf.BackOff {
+func (b *BackOff) getBackoff(ctx context.Context) bf.BackOff {
 	requestExpBackOff := bf.NewExponentialBackOff()
 	requestExpBackOff.InitialInterval = b.initialInterval
 	requestExpBackOff.RandomizationFactor = 0.5
 	requestExpBackOff.Multiplier = 1.5
 	requestExpBackOff.MaxInterval = b.maxInterval
 	requestExpBackOff.MaxElapsedTime = b.maxElapsedTime
-	return bf.WithMaxRetries(requestExpBackOff, b.maxRetry)
+	return bf.WithContext(bf.WithMaxRetries(requestExpBackOff, b.maxRetry), ctx)
 }
@@ -0,0 +1,247 @@
+package source_code
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var BodyTooLargeErr = errors.New("body too large")
+
+// maxPingBytes bounds how much of the ping page is read to look for a challenge.
+const maxPingBytes = 1 << 20
+
+// Meta is a SourceResponse without its body, returned by GetStream.
+type Meta struct {
+	StatusCode int
+	Headers    http.Header
+	FinalURL   string
+	Cookies    []*http.Cookie
+	Getter     string
+	StartedAt  time.Time
+}
+
+// StreamGetter is implemented by getters that can hand back the body as it
+// arrives rather than reading it into memory. Streams are not retried, checked
+// for challenges or validated; textual bodies are still transcoded to UTF-8 and
+// MaxBodyBytes makes Read fail with BodyTooLargeErr once it is passed.
+type StreamGetter interface {
+	GetStream(ctx context.Context, endpoint string, options ...SourceOptions) (io.ReadCloser, *Meta, error)
+}
+
+func (s *SourceResponse) meta() *Meta {
+	return &Meta{
+		StatusCode: s.StatusCode,
+		Headers:    s.Headers,
+		FinalURL:   s.FinalURL,
+		Cookies:    s.Cookies,
+		Getter:     s.Getter,
+		StartedAt:  s.StartedAt,
+	}
+}
+
+// readBody reads and closes resp.Body, failing once it passes maxBytes when
+// maxBytes is set, and transcodes textual bodies to UTF-8.
+func (s *SourceResponse) readBody(resp *http.Response, maxBytes int64) error {
+	defer resp.Body.Close()
+	body, err := io.ReadAll(limitBody(resp.Body, maxBytes))
+	if err != nil {
+		return err
+	}
+	s.Body = body
+	return s.toUTF8()
+}
+
+// toUTF8 transcodes the body using the charset from the Content-Type header, a
+// BOM, a <meta charset> or an XML declaration, and updates the header to match.
+// Bodies that declare nothing are left as they are.
+func (s *SourceResponse) toUTF8() error {
+	ct := s.ContentType()
+	mt := s.MediaType()
+	if !isText(mt) || len(s.Body) == 0 {
+		return nil
+	}
+	enc, name := declaredEncoding(s.Body, ct, mt)
+	if !needsTranscoding(name, utf8.Valid(s.Body)) {
+		return nil
+	}
+	body, err := enc.NewDecoder().Bytes(s.Body)
+	if err != nil {
+		return fmt.Errorf("transcoding %s body: %w", name, err)
+	}
+	s.Body = body
+	s.Headers.Set("Content-Type", withUTF8(ct))
+	return nil
+}
+
+func isText(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "text/") || isXML(mediaType)
+}
+
+func isXML(mediaType string) bool {
+	return strings.HasSuffix(mediaType, "/xml") || strings.HasSuffix(mediaType, "+xml")
+}
+
+// sniffLen is how much of the body is searched for a BOM, <meta> or XML declaration.
+const sniffLen = 1024
+
+var (
+	metaCharset = regexp.MustCompile(`(?i)<meta[^>]+charset\s*=\s*["']?\s*([a-z0-9_:.-]+)`)
+	xmlEncoding = regexp.MustCompile(`^\s*<\?xml[^>]+encoding\s*=\s*["']([A-Za-z0-9_:.-]+)`)
+)
+
+// declaredEncoding returns the encoding head declares through the Content-Type
+// header, a BOM, an HTML <meta> or an XML declaration, or utf-8 when it declares
+// none. XML defaults to UTF-8 and other text is assumed to be, rather than
+// falling back to windows-1252 the way charset.DetermineEncoding does.
+func declaredEncoding(head []byte, contentType, mediaType string) (encoding.Encoding, string) {
+	if len(head) > sniffLen {
+		head = head[:sniffLen]
+	}
+	if enc, name, certain := charset.DetermineEncoding(head, contentType); certain {
+		return enc, name
+	}
+	var match [][]byte
+	if isXML(mediaType) {
+		match = xmlEncoding.FindSubmatch(head)
+	}
+	if match == nil && (mediaType == "text/html" || mediaType == "application/xhtml+xml") {
+		match = metaCharset.FindSubmatch(head)
+	}
+	if match != nil {
+		if enc, name := charset.Lookup(string(match[1])); enc != nil {
+			return enc, name
+		}
+	}
+	return encoding.Nop, "utf-8"
+}
+
+// needsTranscoding reports whether a body in the named encoding must be decoded.
+// Bodies that are already valid UTF-8 are kept whatever they declare, except
+// UTF-16, whose ASCII text is valid UTF-8 byte for byte.
+func needsTranscoding(name string, validUTF8 bool) bool {
+	if name == "utf-8" {
+		return false
+	}
+	return !validUTF8 || strings.HasPrefix(name, "utf-16")
+}
+
+// headIsUTF8 reports whether the start of a stream shows it is UTF-8: it has
+// non-ASCII bytes and they decode, ignoring a sequence cut off at the end.
+// An ASCII-only start says nothing about the rest.
+func headIsUTF8(head []byte) bool {
+	for i := len(head) - 1; i >= 0 && i > len(head)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(head[i]) {
+			if !utf8.FullRune(head[i:]) {
+				head = head[:i]
+			}
+			break
+		}
+	}
+	for _, b := range head {
+		if b >= utf8.RuneSelf {
+			return utf8.Valid(head)
+		}
+	}
+	return false
+}
+
+func withUTF8(contentType string) string {
+	mt, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	params["charset"] = "utf-8"
+	return mime.FormatMediaType(mt, params)
+}
+
+// streamBody wraps resp.Body for GetStream, limiting and transcoding it and
+// calling cancel once it is closed. The encoding is picked on the first Read
+// from the header and the first sniffLen bytes; the Content-Type is only
+// rewritten up front when the header itself names another charset.
+func streamBody(resp *http.Response, maxBytes int64, cancel context.CancelFunc) io.ReadCloser {
+	s := &stream{r: limitBody(resp.Body, maxBytes), body: resp.Body, cancel: cancel}
+	ct := resp.Header.Get("Content-Type")
+	mt, params, _ := mime.ParseMediaType(ct)
+	if isText(mt) {
+		s.contentType, s.mediaType = ct, mt
+		if _, name := charset.Lookup(params["charset"]); name != "" && name != "utf-8" {
+			resp.Header.Set("Content-Type", withUTF8(ct))
+		}
+	}
+	return s
+}
+
+type stream struct {
+	r           io.Reader
+	contentType string
+	mediaType   string
+	body        io.Closer
+	cancel      context.CancelFunc
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	if s.mediaType != "" {
+		br := bufio.NewReaderSize(s.r, sniffLen)
+		// A short or failed peek is fine, br returns the error after the buffered bytes.
+		head, _ := br.Peek(sniffLen)
+		s.r = br
+		if enc, name := declaredEncoding(head, s.contentType, s.mediaType); needsTranscoding(name, headIsUTF8(head)) {
+			s.r = transform.NewReader(br, enc.NewDecoder())
+		}
+		s.mediaType = ""
+	}
+	return s.r.Read(p)
+}
+
+func (s *stream) Close() error {
+	err := s.body.Close()
+	s.cancel()
+	return err
+}
+
+func limitBody(r io.Reader, maxBytes int64) io.Reader {
+	if maxBytes <= 0 {
+		return r
+	}
+	return &limitedReader{r: io.LimitReader(r, maxBytes+1), max: maxBytes, remaining: maxBytes}
+}
+
+type limitedReader struct {
+	r         io.Reader
+	max       int64
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, fmt.Errorf("%w: over %d bytes", BodyTooLargeErr, l.max)
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.remaining {
+		n = int(l.remaining)
+		l.remaining = -1
+		return n, fmt.Errorf("%w: over %d bytes", BodyTooLargeErr, l.max)
+	}
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// streamContext applies MaxDuration to a stream, which lasts until it is closed.
+func (o SourceOptions) streamContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if o.MaxDuration > 0 {
+		return context.WithTimeout(ctx, o.MaxDuration)
+	}
+	return context.WithCancel(ctx)
+}
@@ -0,0 +1,135 @@
+package source_code
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDirectBodyLimitAndCharset(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latin1":
+			w.Header().Set("Content-Type", "text/html; charset=ISO-8859-1")
+			_, _ = w.Write([]byte("<html>caf\xe9</html>"))
+		case "/meta":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte(`<html><head><meta charset="windows-1252"></head><body>na` + "\xef" + `ve</body></html>`))
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte(strings.Repeat("a", 4096)))
+		}
+	}))
+	defer server.Close()
+
+	d := NewDirect(server.Client())
+	d.enabled.Store(true)
+
+	resp, err := d.Get(ctx, server.URL+"/latin1")
+	if err != nil || string(resp.Body) != "<html>café</html>" {
+		t.Fatalf("unexpected body %q: %v", resp.Body, err)
+	}
+	if resp.ContentType() != "text/html; charset=utf-8" {
+		t.Fatalf("expected content type updated, got %s", resp.ContentType())
+	}
+	resp, err = d.Get(ctx, server.URL+"/meta")
+	if err != nil || !strings.Contains(string(resp.Body), "naïve") {
+		t.Fatalf("unexpected body %q: %v", resp.Body, err)
+	}
+
+	resp, err = d.Get(ctx, server.URL+"/large", SourceOptions{MaxBodyBytes: 1024, BackOff: NewBackOff(3, 0, 0, 0)})
+	if !errors.Is(err, BodyTooLargeErr) {
+		t.Fatalf("expected BodyTooLargeErr, got %v", err)
+	}
+	if len(resp.Body) != 0 {
+		t.Fatalf("expected no body, got %d bytes", len(resp.Body))
+	}
+}
+
+func TestDirectGetStream(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=ISO-8859-1")
+		_, _ = w.Write([]byte("caf\xe9 " + strings.Repeat("b", 2048)))
+	}))
+	defer server.Close()
+
+	d := NewDirect(server.Client())
+	d.enabled.Store(true)
+	var _ StreamGetter = d
+
+	body, meta, err := d.GetStream(ctx, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil || !strings.HasPrefix(string(data), "café ") || body.Close() != nil {
+		t.Fatalf("unexpected stream %q: %v", data[:8], err)
+	}
+	if meta.StatusCode != http.StatusOK || meta.Getter != "direct" || meta.Headers.Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Fatalf("unexpected meta %+v", meta)
+	}
+
+	body, _, err = d.GetStream(ctx, server.URL, SourceOptions{MaxBodyBytes: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	if _, err = io.ReadAll(body); !errors.Is(err, BodyTooLargeErr) {
+		t.Fatalf("expected BodyTooLargeErr, got %v", err)
+	}
+}
+
+func TestUndeclaredCharsetKept(t *testing.T) {
+	ctx := context.Background()
+	late := strings.Repeat("a", 1100) + "café"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/xml":
+			w.Header().Set("Content-Type", "application/xml")
+			_, _ = w.Write([]byte(`<?xml version="1.0"?><p>café</p>`))
+		case "/xml-latin1":
+			w.Header().Set("Content-Type", "application/rss+xml")
+			_, _ = w.Write([]byte(`<?xml version="1.0" encoding="ISO-8859-1"?><p>caf` + "\xe9" + `</p>`))
+		case "/mislabelled":
+			w.Header().Set("Content-Type", "text/html; charset=ISO-8859-1")
+			_, _ = w.Write([]byte("<html>café</html>"))
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte(late))
+		}
+	}))
+	defer server.Close()
+
+	d := NewDirect(server.Client())
+	d.enabled.Store(true)
+	for path, want := range map[string]string{
+		"/late":        late,
+		"/xml":         `<?xml version="1.0"?><p>café</p>`,
+		"/xml-latin1":  `<?xml version="1.0" encoding="ISO-8859-1"?><p>café</p>`,
+		"/mislabelled": "<html>café</html>",
+	} {
+		resp, err := d.Get(ctx, server.URL+path)
+		if err != nil || string(resp.Body) != want {
+			t.Fatalf("%s: unexpected body %q: %v", path, resp.Body, err)
+		}
+		if path == "/late" && resp.ContentType() != "text/plain" {
+			t.Fatalf("expected the content type kept, got %s", resp.ContentType())
+		}
+	}
+
+	body, meta, err := d.GetStream(ctx, server.URL+"/late")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil || string(data) != late || meta.Headers.Get("Content-Type") != "text/plain" {
+		t.Fatalf("unexpected stream %q %s: %v", data[len(data)-8:], meta.Headers.Get("Content-Type"), err)
+	}
+}
@@ -0,0 +1,524 @@
+package source_code
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var _ SourceGetter = &Browser{}
+
+// networkIdleConnections is how many requests may stay open, such as a long poll
+// or an analytics beacon, while the page still counts as idle.
+const networkIdleConnections = 2
+
+var DefaultBrowserFlags = []string{
+	"--headless=new",
+	"--disable-gpu",
+	"--no-first-run",
+	"--no-default-browser-check",
+	"--remote-debugging-port=0",
+}
+
+// Browser renders pages in Chromium driven over the DevTools Protocol. It connects
+// to a running instance at DebuggerURL (e.g. http://localhost:9222) or, when that
+// is empty, launches ExecPath. Each Get opens a tab in a fresh browser context,
+// waits for the load event followed by NetworkIdle with at most two requests in
+// flight, like Puppeteer's networkidle2, or for WaitSelector, and returns the DOM.
+type Browser struct {
+	client       *http.Client
+	enabled      atomic.Bool
+	DebuggerURL  string
+	ExecPath     string
+	Flags        []string
+	NetworkIdle  time.Duration
+	WaitSelector string
+	Screenshot   bool
+	Timeout      time.Duration
+
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	dataDir string
+	wsURL   string
+}
+
+func BrowserFlags() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("browser", pflag.ExitOnError)
+	fs.String("browser-debugger-url", "", "DevTools URL of a running browser, e.g. http://localhost:9222")
+	fs.String("browser-exec-path", "chromium", "Browser to launch when no debugger url is set")
+	fs.Duration("browser-network-idle", 500*time.Millisecond, "Time with at most two requests in flight before the page is considered loaded")
+	fs.String("browser-wait-selector", "", "CSS selector to wait for instead of network idle")
+	fs.Bool("browser-screenshot", false, "Capture a screenshot of each page")
+	fs.Duration("browser-timeout", 60*time.Second, "Maximum time to render a page")
+	return fs
+}
+
+func NewBrowserFromFlags(client *http.Client) *Browser {
+	return &Browser{
+		client:       client,
+		DebuggerURL:  viper.GetString("browser-debugger-url"),
+		ExecPath:     viper.GetString("browser-exec-path"),
+		Flags:        DefaultBrowserFlags,
+		NetworkIdle:  viper.GetDuration("browser-network-idle"),
+		WaitSelector: viper.GetString("browser-wait-selector"),
+		Screenshot:   viper.GetBool("browser-screenshot"),
+		Timeout:      viper.GetDuration("browser-timeout"),
+	}
+}
+
+func NewBrowser(client *http.Client, debuggerURL string) *Browser {
+	return &Browser{
+		client:      client,
+		DebuggerURL: debuggerURL,
+		ExecPath:    "chromium",
+		Flags:       DefaultBrowserFlags,
+		NetworkIdle: 500 * time.Millisecond,
+		Timeout:     60 * time.Second,
+	}
+}
+
+func (b *Browser) Get(ctx context.Context, endpoint string, options ...SourceOptions) (*SourceResponse, error) {
+	o := mergeSourceOptions(options...)
+	return o.run(ctx, func(ctx context.Context) (*SourceResponse, error) {
+		return b.get(ctx, endpoint, o)
+	})
+}
+
+func (b *Browser) get(ctx context.Context, endpoint string, o SourceOptions) (*SourceResponse, error) {
+	sr := newSourceResponse(b.Name(), time.Now())
+	if !b.Enabled() {
+		sr.StatusCode = http.StatusNotImplemented
+		return sr.done(), fmt.Errorf("%s is %w", b.Name(), NotEnabledErr)
+	}
+	if o.method() != http.MethodGet {
+		sr.StatusCode = http.StatusMethodNotAllowed
+		return sr.done(), fmt.Errorf("%s does not support method %s", b.Name(), o.method())
+	}
+	if b.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.Timeout)
+		defer cancel()
+	}
+	conn, err := b.connect(ctx)
+	if err != nil {
+		sr.StatusCode = http.StatusServiceUnavailable
+		return sr.done(), err
+	}
+	defer conn.Close()
+
+	// Each Get gets its own browser context, so cookies and storage from one
+	// page never reach another. Disposing of the context closes its tab.
+	browserContext := struct {
+		BrowserContextID string `json:"browserContextId"`
+	}{}
+	if err = conn.call(ctx, "", "Target.createBrowserContext", map[string]interface{}{"disposeOnDetach": true}, &browserContext); err != nil {
+		return sr.done(), err
+	}
+	defer func() {
+		closeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = conn.call(closeCtx, "", "Target.disposeBrowserContext", map[string]interface{}{"browserContextId": browserContext.BrowserContextID}, nil)
+	}()
+	target := struct {
+		TargetID string `json:"targetId"`
+	}{}
+	if err = conn.call(ctx, "", "Target.createTarget", map[string]interface{}{"url": "about:blank", "browserContextId": browserContext.BrowserContextID}, &target); err != nil {
+		return sr.done(), err
+	}
+	session := struct {
+		SessionID string `json:"sessionId"`
+	}{}
+	if err = conn.call(ctx, "", "Target.attachToTarget", map[string]interface{}{"targetId": target.TargetID, "flatten": true}, &session); err != nil {
+		return sr.done(), err
+	}
+	page := &cdpPage{conn: conn, session: session.SessionID}
+	if err = page.render(ctx, endpoint, o, b.NetworkIdle, b.waitSelector(o)); err != nil {
+		sr.StatusCode = page.status
+		return sr.done(), err
+	}
+
+	sr.StatusCode = page.status
+	sr.Headers = page.headers
+	if sr.Body, err = page.html(ctx); err != nil {
+		return sr.done(), err
+	}
+	if sr.FinalURL, err = page.location(ctx); err != nil {
+		return sr.done(), err
+	}
+	if sr.Cookies, err = page.cookies(ctx, sr.FinalURL); err != nil {
+		return sr.done(), err
+	}
+	if b.Screenshot {
+		if sr.Screenshot, err = page.screenshot(ctx); err != nil {
+			return sr.done(), err
+		}
+	}
+	if err = detectChallengeErr(sr.StatusCode, sr.Headers, sr.Body); err != nil {
+		return sr.done(), err
+	}
+	return sr.done(), nil
+}
+
+func (b *Browser) waitSelector(o SourceOptions) string {
+	if o.WaitSelector != "" {
+		return o.WaitSelector
+	}
+	return b.WaitSelector
+}
+
+// connect dials the browser-level DevTools websocket. A launched browser that
+// refuses the connection has most likely crashed, so it is stopped and launched again.
+func (b *Browser) connect(ctx context.Context) (*cdpConn, error) {
+	wsURL, err := b.webSocketURL(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialCDP(ctx, wsURL)
+	if err == nil || b.DebuggerURL != "" || ctx.Err() != nil {
+		return conn, err
+	}
+	b.mu.Lock()
+	if b.wsURL == wsURL {
+		_ = b.stop()
+	}
+	b.mu.Unlock()
+	if wsURL, err = b.webSocketURL(ctx); err != nil {
+		return nil, err
+	}
+	return dialCDP(ctx, wsURL)
+}
+
+// webSocketURL returns the browser-level DevTools websocket, launching ExecPath if needed.
+func (b *Browser) webSocketURL(ctx context.Context) (string, error) {
+	if b.DebuggerURL != "" {
+		return b.discover(ctx)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.wsURL != "" {
+		return b.wsURL, nil
+	}
+	return b.launch()
+}
+
+func (b *Browser) discover(ctx context.Context) (string, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(b.DebuggerURL, "/")+"/json/version", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := b.client.Do(r)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	version := struct {
+		WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(&version); err != nil {
+		return "", err
+	}
+	if version.WebSocketDebuggerURL == "" {
+		return "", fmt.Errorf("%s did not report a websocket debugger url", b.DebuggerURL)
+	}
+	return version.WebSocketDebuggerURL, nil
+}
+
+// launch starts the browser and reads its websocket url from the
+// "DevTools listening on" line it prints to stderr. The caller holds b.mu.
+func (b *Browser) launch() (string, error) {
+	dataDir, err := os.MkdirTemp("", "wp-browser-")
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command(b.ExecPath, append(append([]string{}, b.Flags...), "--user-data-dir="+dataDir, "about:blank")...)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		_ = os.RemoveAll(dataDir)
+		return "", err
+	}
+	if err = cmd.Start(); err != nil {
+		_ = os.RemoveAll(dataDir)
+		return "", err
+	}
+	found := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			if _, wsURL, ok := strings.Cut(scanner.Text(), "DevTools listening on "); ok {
+				found <- strings.TrimSpace(wsURL)
+			}
+		}
+		close(found)
+	}()
+	select {
+	case wsURL, ok := <-found:
+		if !ok {
+			_ = cmd.Wait()
+			_ = os.RemoveAll(dataDir)
+			return "", errors.New("browser exited before DevTools was available")
+		}
+		b.cmd, b.dataDir, b.wsURL = cmd, dataDir, wsURL
+		return wsURL, nil
+	case <-time.After(30 * time.Second):
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		_ = os.RemoveAll(dataDir)
+		return "", errors.New("timed out waiting for browser DevTools")
+	}
+}
+
+// Close stops a browser launched by Get or Ping. Connected browsers are left running.
+func (b *Browser) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stop()
+}
+
+// stop kills the launched browser and removes its profile. The caller holds b.mu.
+func (b *Browser) stop() error {
+	if b.cmd == nil {
+		return nil
+	}
+	_ = b.cmd.Process.Kill()
+	_ = b.cmd.Wait()
+	err := os.RemoveAll(b.dataDir)
+	b.cmd, b.dataDir, b.wsURL = nil, "", ""
+	return err
+}
+
+// Ping connects to the browser, launching it if needed, and asks for its version.
+func (b *Browser) Ping(ctx context.Context) HealthStatus {
+	status := HealthStatus{Getter: b.Name(), CheckedAt: time.Now()}
+	status.Err = b.ping(ctx)
+	status.Healthy = status.Err == nil
+	status.Latency = time.Since(status.CheckedAt)
+	b.enabled.Store(status.Healthy)
+	return status
+}
+
+func (b *Browser) ping(ctx context.Context) error {
+	conn, err := b.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.call(ctx, "", "Browser.getVersion", nil, nil)
+}
+
+func (b *Browser) Enabled() bool {
+	return b.enabled.Load()
+}
+
+// HandlesChallenge reports true for javascript challenges, which a real browser runs.
+func (b *Browser) HandlesChallenge(info ChallengeInfo) bool {
+	return info.Kind == ChallengeKindJS
+}
+
+func (b *Browser) Name() string {
+	return "browser"
+}
+
+type cdpPage struct {
+	conn    *cdpConn
+	session string
+	status  int
+	headers http.Header
+}
+
+type cdpNetworkEvent struct {
+	RequestID string `json:"requestId"`
+	LoaderID  string `json:"loaderId"`
+	Type      string `json:"type"`
+	FrameID   string `json:"frameId"`
+	Response  struct {
+		URL     string            `json:"url"`
+		Status  int               `json:"status"`
+		Headers map[string]string `json:"headers"`
+	} `json:"response"`
+}
+
+// render navigates to endpoint and waits for the page to settle.
+func (p *cdpPage) render(ctx context.Context, endpoint string, o SourceOptions, networkIdle time.Duration, selector string) error {
+	events := p.conn.subscribe(p.session,
+		"Network.requestWillBeSent",
+		"Network.loadingFinished",
+		"Network.loadingFailed",
+		"Network.responseReceived",
+		"Page.loadEventFired",
+	)
+
+	for _, method := range []string{"Page.enable", "Network.enable", "Runtime.enable"} {
+		if err := p.conn.call(ctx, p.session, method, nil, nil); err != nil {
+			return err
+		}
+	}
+	if o.UserAgent != "" {
+		if err := p.conn.call(ctx, p.session, "Network.setUserAgentOverride", map[string]interface{}{"userAgent": o.UserAgent}, nil); err != nil {
+			return err
+		}
+	}
+	if len(o.Headers) > 0 {
+		headers := map[string]string{}
+		for key := range o.Headers {
+			headers[key] = o.Headers.Get(key)
+		}
+		if err := p.conn.call(ctx, p.session, "Network.setExtraHTTPHeaders", map[string]interface{}{"headers": headers}, nil); err != nil {
+			return err
+		}
+	}
+	if len(o.Cookies) > 0 {
+		cookies := make([]map[string]interface{}, 0, len(o.Cookies))
+		for _, c := range o.Cookies {
+			cookies = append(cookies, map[string]interface{}{"name": c.Name, "value": c.Value, "url": endpoint})
+		}
+		if err := p.conn.call(ctx, p.session, "Network.setCookies", map[string]interface{}{"cookies": cookies}, nil); err != nil {
+			return err
+		}
+	}
+
+	navigation := struct {
+		FrameID   string `json:"frameId"`
+		LoaderID  string `json:"loaderId"`
+		ErrorText string `json:"errorText"`
+	}{}
+	if err := p.conn.call(ctx, p.session, "Page.navigate", map[string]interface{}{"url": endpoint}, &navigation); err != nil {
+		return err
+	}
+	if navigation.ErrorText != "" {
+		return fmt.Errorf("navigating to %s: %s", endpoint, navigation.ErrorText)
+	}
+
+	inflight := map[string]bool{}
+	busy := true
+	isLoaded := false
+	idle := time.NewTimer(networkIdle)
+	defer idle.Stop()
+	poll := time.NewTicker(100 * time.Millisecond)
+	defer poll.Stop()
+	resetIdle := func() {
+		if !idle.Stop() {
+			select {
+			case <-idle.C:
+			default:
+			}
+		}
+		idle.Reset(networkIdle)
+	}
+	for {
+		select {
+		case <-events.Ready():
+			for _, msg := range events.take() {
+				e := cdpNetworkEvent{}
+				_ = json.Unmarshal(msg.Params, &e)
+				switch msg.Method {
+				case "Network.requestWillBeSent":
+					inflight[e.RequestID] = true
+				case "Network.loadingFinished", "Network.loadingFailed":
+					delete(inflight, e.RequestID)
+				case "Network.responseReceived":
+					if e.Type == "Document" && e.FrameID == navigation.FrameID {
+						p.status = e.Response.Status
+						p.headers = http.Header{}
+						for key, value := range e.Response.Headers {
+							for _, v := range strings.Split(value, "\n") {
+								p.headers.Add(key, v)
+							}
+						}
+					}
+				case "Page.loadEventFired":
+					isLoaded = true
+				}
+				// The idle window starts when the page drops to networkIdleConnections.
+				if len(inflight) > networkIdleConnections {
+					busy = true
+				} else if busy {
+					busy = false
+					resetIdle()
+				}
+			}
+		case <-poll.C:
+			if !isLoaded || selector == "" {
+				continue
+			}
+			found := false
+			if err := p.evaluate(ctx, fmt.Sprintf("document.querySelector(%q) !== null", selector), &found); err != nil {
+				return err
+			}
+			if found {
+				return nil
+			}
+		case <-idle.C:
+			if isLoaded && selector == "" && !busy {
+				return nil
+			}
+			idle.Reset(networkIdle)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (p *cdpPage) evaluate(ctx context.Context, expression string, value interface{}) error {
+	result := struct {
+		Result struct {
+			Value json.RawMessage `json:"value"`
+		} `json:"result"`
+		ExceptionDetails *struct {
+			Text string `json:"text"`
+		} `json:"exceptionDetails"`
+	}{}
+	err := p.conn.call(ctx, p.session, "Runtime.evaluate", map[string]interface{}{"expression": expression, "returnByValue": true}, &result)
+	if err != nil {
+		return err
+	}
+	if result.ExceptionDetails != nil {
+		return fmt.Errorf("evaluating %q: %s", expression, result.ExceptionDetails.Text)
+	}
+	return json.Unmarshal(result.Result.Value, value)
+}
+
+func (p *cdpPage) html(ctx context.Context) ([]byte, error) {
+	html := ""
+	if err := p.evaluate(ctx, "document.documentElement.outerHTML", &html); err != nil {
+		return nil, err
+	}
+	return []byte(html), nil
+}
+
+func (p *cdpPage) location(ctx context.Context) (string, error) {
+	location := ""
+	err := p.evaluate(ctx, "window.location.href", &location)
+	return location, err
+}
+
+func (p *cdpPage) cookies(ctx context.Context, endpoint string) ([]*http.Cookie, error) {
+	result := struct {
+		Cookies FlareCookies `json:"cookies"`
+	}{}
+	if err := p.conn.call(ctx, p.session, "Network.getCookies", map[string]interface{}{"urls": []string{endpoint}}, &result); err != nil {
+		return nil, err
+	}
+	return result.Cookies.HTTPCookies(), nil
+}
+
+func (p *cdpPage) screenshot(ctx context.Context) ([]byte, error) {
+	result := struct {
+		Data string `json:"data"`
+	}{}
+	if err := p.conn.call(ctx, p.session, "Page.captureScreenshot", map[string]interface{}{"format": "png"}, &result); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(result.Data)
+}
@@ -0,0 +1,230 @@
+package source_code
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePage shapes the page fakeCDP renders and records the commands it was sent.
+type fakePage struct {
+	mu sync.Mutex
+	// Noise finished requests are sent ahead of the load event and Open requests
+	// that never finish after it, like a long poll or an analytics beacon.
+	Noise   int
+	Open    int
+	methods []string
+}
+
+func (f *fakePage) record(msg cdpMessage, params map[string]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	method := msg.Method
+	if id, ok := params["browserContextId"].(string); ok {
+		method += " " + id
+	}
+	f.methods = append(f.methods, method)
+}
+
+func (f *fakePage) set(noise, open int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Noise, f.Open = noise, open
+}
+
+func (f *fakePage) calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.methods...)
+}
+
+// fakeCDP answers the DevTools commands Browser sends, rendering a fixed page.
+func fakeCDP(t *testing.T) (*httptest.Server, *fakePage) {
+	page := &fakePage{}
+	upgrader := websocket.Upgrader{}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/json/version" {
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"webSocketDebuggerUrl": "ws" + strings.TrimPrefix(server.URL, "http") + "/devtools/browser/fake",
+			})
+			return
+		}
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer ws.Close()
+		for {
+			msg := cdpMessage{}
+			if err = ws.ReadJSON(&msg); err != nil {
+				return
+			}
+			params := map[string]interface{}{}
+			_ = json.Unmarshal(msg.Params, &params)
+			page.record(msg, params)
+			result := map[string]interface{}{}
+			var events []cdpMessage
+			event := func(method string, params interface{}) {
+				b, _ := json.Marshal(params)
+				events = append(events, cdpMessage{SessionID: msg.SessionID, Method: method, Params: b})
+			}
+			switch msg.Method {
+			case "Target.createBrowserContext":
+				result["browserContextId"] = "context-1"
+			case "Target.createTarget":
+				result["targetId"] = "target-1"
+			case "Target.attachToTarget":
+				result["sessionId"] = "session-1"
+			case "Page.navigate":
+				result["frameId"] = "frame-1"
+				result["loaderId"] = "loader-1"
+				page.mu.Lock()
+				noise, open := page.Noise, page.Open
+				page.mu.Unlock()
+				event("Network.requestWillBeSent", map[string]string{"requestId": "r1"})
+				for i := 0; i < noise; i++ {
+					event("Network.requestWillBeSent", map[string]string{"requestId": fmt.Sprintf("noise-%d", i)})
+					event("Network.loadingFinished", map[string]string{"requestId": fmt.Sprintf("noise-%d", i)})
+				}
+				event("Network.responseReceived", map[string]interface{}{
+					"requestId": "r1", "type": "Document", "frameId": "frame-1",
+					"response": map[string]interface{}{"url": params["url"], "status": 200, "headers": map[string]string{"content-type": "text/html"}},
+				})
+				event("Network.loadingFinished", map[string]string{"requestId": "r1"})
+				event("Page.loadEventFired", map[string]float64{"timestamp": 1})
+				for i := 0; i < open; i++ {
+					event("Network.requestWillBeSent", map[string]string{"requestId": fmt.Sprintf("open-%d", i)})
+				}
+			case "Runtime.evaluate":
+				switch expression := params["expression"].(string); {
+				case strings.Contains(expression, "outerHTML"):
+					result["result"] = map[string]string{"value": `<html><body><div class="rendered">hello</div></body></html>`}
+				case strings.Contains(expression, "location.href"):
+					result["result"] = map[string]string{"value": "https://example.com/rendered"}
+				case strings.Contains(expression, "querySelector"):
+					result["result"] = map[string]bool{"value": strings.Contains(expression, ".rendered")}
+				}
+			case "Network.getCookies":
+				result["cookies"] = []map[string]interface{}{{"name": "session", "value": "abc", "domain": "example.com", "path": "/"}}
+			case "Page.captureScreenshot":
+				result["data"] = base64.StdEncoding.EncodeToString([]byte("png"))
+			}
+			// Events go out before the result, as Chrome sends them while navigating,
+			// so they pile up before Browser starts reading them.
+			for _, e := range events {
+				if err = ws.WriteJSON(e); err != nil {
+					return
+				}
+			}
+			b, _ := json.Marshal(result)
+			if err = ws.WriteJSON(cdpMessage{ID: msg.ID, Result: b}); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, page
+}
+
+func TestBrowser(t *testing.T) {
+	ctx := context.Background()
+	server, page := fakeCDP(t)
+	b := NewBrowser(server.Client(), server.URL)
+	b.NetworkIdle = 10 * time.Millisecond
+	b.Screenshot = true
+	if !b.Ping(ctx).Healthy {
+		t.Fatal("expected browser to be reachable")
+	}
+
+	resp, err := b.Get(ctx, "https://example.com/", SourceOptions{WaitSelector: ".rendered"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(resp.Body), "hello") || resp.StatusCode != http.StatusOK || resp.MediaType() != "text/html" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	if resp.FinalURL != "https://example.com/rendered" || len(resp.Cookies) != 1 || string(resp.Screenshot) != "png" {
+		t.Fatalf("unexpected page metadata %+v", resp)
+	}
+
+	resp, err = b.Get(ctx, "https://example.com/")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected network idle render, got %v %v", resp, err)
+	}
+	calls := page.calls()
+	for _, want := range []string{"Target.createBrowserContext", "Target.createTarget context-1", "Target.disposeBrowserContext context-1"} {
+		if !slices.Contains(calls, want) {
+			t.Fatalf("expected %s, got %v", want, calls)
+		}
+	}
+}
+
+func TestBrowserNetworkIdle(t *testing.T) {
+	ctx := context.Background()
+	server, page := fakeCDP(t)
+	b := NewBrowser(server.Client(), server.URL)
+	b.NetworkIdle = 10 * time.Millisecond
+	b.Timeout = 2 * time.Second
+	if !b.Ping(ctx).Healthy {
+		t.Fatal("expected browser to be reachable")
+	}
+
+	// Two requests left open still count as idle; the burst overflows any fixed buffer.
+	page.set(3000, 2)
+	if _, err := b.Get(ctx, "https://example.com/"); err != nil {
+		t.Fatalf("expected idle with two open requests and every event delivered, got %v", err)
+	}
+	page.set(0, 3)
+	b.Timeout = 200 * time.Millisecond
+	if _, err := b.Get(ctx, "https://example.com/"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected three open requests to never be idle, got %v", err)
+	}
+}
+
+func TestBrowserRelaunch(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake browser is a shell script")
+	}
+	server, _ := fakeCDP(t)
+	dir := t.TempDir()
+	// The first launch reports a dead websocket, as if the browser crashed
+	// right after starting; later launches report the fake DevTools server.
+	script := fmt.Sprintf(`#!/bin/sh
+if [ -e %[1]q/launched ]; then
+	echo "DevTools listening on %[2]s" >&2
+else
+	touch %[1]q/launched
+	echo "DevTools listening on ws://127.0.0.1:1/devtools/browser/crashed" >&2
+fi
+exec sleep 60
+`, dir, "ws"+strings.TrimPrefix(server.URL, "http")+"/devtools/browser/fake")
+	execPath := filepath.Join(dir, "browser")
+	if err := os.WriteFile(execPath, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	b := NewBrowser(server.Client(), "")
+	b.ExecPath = execPath
+	defer b.Close()
+
+	if status := b.Ping(context.Background()); !status.Healthy {
+		t.Fatalf("expected the crashed browser to be relaunched, got %v", status.Err)
+	}
+	if !strings.HasSuffix(b.wsURL, "/devtools/browser/fake") {
+		t.Fatalf("expected the relaunched browser's websocket, got %s", b.wsURL)
+	}
+}
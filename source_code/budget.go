@@ -0,0 +1,276 @@
+package source_code
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Seann-Moser/cutil/logc"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var _ SourceGetter = &Budgeted{}
+var _ CostedGetter = &Budgeted{}
+
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+func BudgetFlags(prefix string) *pflag.FlagSet {
+	fs := pflag.NewFlagSet(GetFlagWithPrefix("budget", prefix), pflag.ExitOnError)
+	fs.Float64(GetFlagWithPrefix("budget-daily", prefix), 0, "credits that may be spent per day, 0 for no cap")
+	fs.Float64(GetFlagWithPrefix("budget-monthly", prefix), 0, "credits that may be spent per month, 0 for no cap")
+	fs.Float64(GetFlagWithPrefix("budget-cost-per-request", prefix), 0, "credits charged per request when the getter does not report a cost")
+	fs.String(GetFlagWithPrefix("budget-path", prefix), "", "file the spend counters are persisted to")
+	return fs
+}
+
+func NewBudgetedWithFlags(getter SourceGetter, prefix string) (*Budgeted, error) {
+	b, err := NewBudgetedFromFile(getter, viper.GetString(GetFlagWithPrefix("budget-path", prefix)),
+		viper.GetFloat64(GetFlagWithPrefix("budget-daily", prefix)),
+		viper.GetFloat64(GetFlagWithPrefix("budget-monthly", prefix)))
+	if err != nil {
+		return nil, err
+	}
+	b.CostPerRequest = viper.GetFloat64(GetFlagWithPrefix("budget-cost-per-request", prefix))
+	return b, nil
+}
+
+// GetterSpend is the requests made and credits spent by one getter this month.
+type GetterSpend struct {
+	Requests int64   `json:"requests"`
+	Credits  float64 `json:"credits"`
+}
+
+// Spend is the state Budgeted persists. Day and Month name the current periods;
+// counters reset when the clock moves past them.
+type Spend struct {
+	Day     string                 `json:"day"`
+	Month   string                 `json:"month"`
+	Daily   float64                `json:"daily"`
+	Monthly float64                `json:"monthly"`
+	Getters map[string]GetterSpend `json:"getters"`
+}
+
+// Budgeted wraps a paid getter, usually ZenRows or a Fallback containing it, and
+// refuses requests with ErrBudgetExceeded once the daily or monthly cap is spent
+// so a surrounding Fallback moves on. A request's cost is SourceResponse.Cost when
+// the getter reports one. Otherwise, when the getter that served it is a
+// CostedGetter with a non-zero Cost, it is CostPerRequest or that Cost; free
+// getters in a wrapped Fallback, such as Direct, are not charged.
+// Concurrent requests are checked before they are charged, so a cap can be
+// overshot by the requests in flight.
+type Budgeted struct {
+	getter         SourceGetter
+	Daily          float64
+	Monthly        float64
+	CostPerRequest float64
+	Path           string
+
+	mu    sync.Mutex
+	spend Spend
+	now   func() time.Time
+}
+
+func NewBudgeted(getter SourceGetter, daily, monthly float64) *Budgeted {
+	return &Budgeted{
+		getter:  getter,
+		Daily:   daily,
+		Monthly: monthly,
+		now:     time.Now,
+	}
+}
+
+// NewBudgetedFromFile restores the counters saved at path, which need not exist yet.
+func NewBudgetedFromFile(getter SourceGetter, path string, daily, monthly float64) (*Budgeted, error) {
+	b := NewBudgeted(getter, daily, monthly)
+	b.Path = path
+	if path == "" {
+		return b, nil
+	}
+	if err := b.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *Budgeted) Get(ctx context.Context, endpoint string, options ...SourceOptions) (*SourceResponse, error) {
+	if err := b.check(); err != nil {
+		sr := newSourceResponse(b.Name(), time.Now())
+		sr.StatusCode = http.StatusPaymentRequired
+		return sr.done(), err
+	}
+	resp, err := b.getter.Get(ctx, endpoint, options...)
+	if resp != nil {
+		b.charge(ctx, resp, err)
+	}
+	return resp, err
+}
+
+func (b *Budgeted) check() error {
+	expected := b.expectedCost()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollover()
+	if b.Daily > 0 && (b.spend.Daily >= b.Daily || b.spend.Daily+expected > b.Daily) {
+		return fmt.Errorf("%s daily %w: spent %g of %g", b.Name(), ErrBudgetExceeded, b.spend.Daily, b.Daily)
+	}
+	if b.Monthly > 0 && (b.spend.Monthly >= b.Monthly || b.spend.Monthly+expected > b.Monthly) {
+		return fmt.Errorf("%s monthly %w: spent %g of %g", b.Name(), ErrBudgetExceeded, b.spend.Monthly, b.Monthly)
+	}
+	return nil
+}
+
+func (b *Budgeted) expectedCost() float64 {
+	if b.CostPerRequest > 0 {
+		return b.CostPerRequest
+	}
+	if costed, ok := asGetter[CostedGetter](b.getter); ok {
+		return costed.Cost()
+	}
+	return 0
+}
+
+// charge records the request. Failed requests only cost what the getter reported.
+func (b *Budgeted) charge(ctx context.Context, resp *SourceResponse, err error) {
+	cost := resp.Cost
+	if cost == 0 && err == nil {
+		cost = b.servedCost(resp.Getter)
+	}
+	b.mu.Lock()
+	b.rollover()
+	b.spend.Daily += cost
+	b.spend.Monthly += cost
+	if b.spend.Getters == nil {
+		b.spend.Getters = make(map[string]GetterSpend)
+	}
+	name := resp.Getter
+	if name == "" {
+		name = b.getter.Name()
+	}
+	g := b.spend.Getters[name]
+	g.Requests++
+	g.Credits += cost
+	b.spend.Getters[name] = g
+	b.mu.Unlock()
+	if b.Path == "" {
+		return
+	}
+	if err := b.Save(); err != nil {
+		logc.Warn(ctx, "failed saving budget", zap.String("path", b.Path), zap.Error(err))
+	}
+}
+
+// servedCost is what a request served by the getter named name costs when it
+// reported nothing: the member of a wrapped Fallback with that name, or the
+// wrapped getter itself, priced only when it is a CostedGetter that charges.
+func (b *Budgeted) servedCost(name string) float64 {
+	served := b.getter
+	if f, ok := asGetter[*Fallback](b.getter); ok {
+		served = nil
+		for _, getter := range f.Getters {
+			if getter.Name() == name {
+				served = getter
+				break
+			}
+		}
+	}
+	costed, ok := asGetter[CostedGetter](served)
+	if !ok || costed.Cost() == 0 {
+		return 0
+	}
+	if b.CostPerRequest > 0 {
+		return b.CostPerRequest
+	}
+	return costed.Cost()
+}
+
+// rollover resets the counters whose period has passed. b.mu must be held.
+func (b *Budgeted) rollover() {
+	now := b.clock()
+	day, month := now.Format(time.DateOnly), now.Format("2006-01")
+	if b.spend.Month != month {
+		b.spend.Month = month
+		b.spend.Monthly = 0
+		b.spend.Getters = nil
+	}
+	if b.spend.Day != day {
+		b.spend.Day = day
+		b.spend.Daily = 0
+	}
+}
+
+func (b *Budgeted) clock() time.Time {
+	if b.now == nil {
+		return time.Now()
+	}
+	return b.now()
+}
+
+// Spend returns a copy of the current counters.
+func (b *Budgeted) Spend() Spend {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollover()
+	spend := b.spend
+	spend.Getters = make(map[string]GetterSpend, len(b.spend.Getters))
+	for name, g := range b.spend.Getters {
+		spend.Getters[name] = g
+	}
+	return spend
+}
+
+func (b *Budgeted) Save() error {
+	data, err := json.MarshalIndent(b.Spend(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(b.Path, data)
+}
+
+// Load replaces the counters with the ones saved at Path.
+func (b *Budgeted) Load() error {
+	data, err := os.ReadFile(b.Path)
+	if err != nil {
+		return err
+	}
+	spend := Spend{}
+	if err = json.Unmarshal(data, &spend); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.spend = spend
+	return nil
+}
+
+func (b *Budgeted) Ping(ctx context.Context) HealthStatus {
+	return b.getter.Ping(ctx)
+}
+
+func (b *Budgeted) Enabled() bool {
+	return b.getter.Enabled()
+}
+
+func (b *Budgeted) Cost() float64 {
+	return b.expectedCost()
+}
+
+// HandlesChallenge forwards to the wrapped getter so Fallback still escalates to it.
+func (b *Budgeted) HandlesChallenge(info ChallengeInfo) bool {
+	handler, ok := asGetter[ChallengeHandler](b.getter)
+	return ok && handler.HandlesChallenge(info)
+}
+
+func (b *Budgeted) Name() string {
+	return b.getter.Name()
+}
+
+// Unwrap returns the getter whose spend is tracked.
+func (b *Budgeted) Unwrap() SourceGetter {
+	return b.getter
+}
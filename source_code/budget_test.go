@@ -0,0 +1,120 @@
+package source_code
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBudgeted(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "budget.json")
+	now := time.Date(2026, 3, 31, 12, 0, 0, 0, time.UTC)
+
+	paid := &stubGetter{name: "zen-rows", cost: 10}
+	b, err := NewBudgetedFromFile(paid, path, 25, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.now = func() time.Time { return now }
+	for i := 0; i < 2; i++ {
+		if _, err = b.Get(ctx, "https://example.com"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	resp, err := b.Get(ctx, "https://example.com")
+	if !errors.Is(err, ErrBudgetExceeded) || resp.StatusCode != 402 {
+		t.Fatalf("expected daily budget exceeded, got %v", err)
+	}
+	if paid.gets.Load() != 2 {
+		t.Fatalf("expected the getter to be called twice, got %d", paid.gets.Load())
+	}
+
+	restored, err := NewBudgetedFromFile(paid, path, 25, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored.now = func() time.Time { return now }
+	spend := restored.Spend()
+	if spend.Daily != 20 || spend.Monthly != 20 || spend.Getters["zen-rows"].Requests != 2 {
+		t.Fatalf("unexpected restored spend %+v", spend)
+	}
+
+	now = now.Add(24 * time.Hour)
+	if _, err = restored.Get(ctx, "https://example.com"); err != nil {
+		t.Fatalf("expected a new month to reset the budget: %v", err)
+	}
+	if spend = restored.Spend(); spend.Month != "2026-04" || spend.Monthly != 10 {
+		t.Fatalf("unexpected spend after rollover %+v", spend)
+	}
+
+	registry := prometheus.NewRegistry()
+	metrics, err := NewMetrics(registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics.AddBudget(restored)
+	expected := `
+# HELP wp_budget_getter_requests Requests made by each getter this month.
+# TYPE wp_budget_getter_requests gauge
+wp_budget_getter_requests{budget="zen-rows",getter="zen-rows"} 1
+# HELP wp_budget_limit_credits Credits allowed per period, 0 when uncapped.
+# TYPE wp_budget_limit_credits gauge
+wp_budget_limit_credits{budget="zen-rows",period="daily"} 25
+wp_budget_limit_credits{budget="zen-rows",period="monthly"} 100
+# HELP wp_budget_spent_credits Credits spent in the current period.
+# TYPE wp_budget_spent_credits gauge
+wp_budget_spent_credits{budget="zen-rows",period="daily"} 10
+wp_budget_spent_credits{budget="zen-rows",period="monthly"} 10
+`
+	if err = testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		"wp_budget_spent_credits", "wp_budget_limit_credits", "wp_budget_getter_requests"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFallbackSkipsExhaustedBudget(t *testing.T) {
+	ctx := context.Background()
+	paid := NewBudgeted(&stubGetter{name: "zen-rows", cost: 1}, 0.5, 0)
+	free := &stubGetter{name: "direct"}
+	f := NewFallback(paid, free)
+	f.FailureThreshold = 1
+	for i := 0; i < 3; i++ {
+		resp, err := f.Get(ctx, "https://example.com")
+		if err != nil || resp.Getter != "direct" {
+			t.Fatalf("expected direct to serve, got %v %v", resp, err)
+		}
+	}
+	for _, h := range f.Health() {
+		if h.Name == "zen-rows" && h.State != CircuitClosed {
+			t.Fatalf("budget errors should not open the circuit, got %s", h.State)
+		}
+	}
+}
+
+func TestBudgetedFallbackChargesPaidGetters(t *testing.T) {
+	ctx := context.Background()
+	free := &stubGetter{name: "direct"}
+	paid := &stubGetter{name: "zen-rows", cost: 10}
+	b := NewBudgeted(NewFallback(free, paid), 0, 100)
+	b.CostPerRequest = 5
+
+	if _, err := b.Get(ctx, "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if spend := b.Spend(); spend.Monthly != 0 || spend.Getters["direct"].Requests != 1 {
+		t.Fatalf("expected the free getter not to be charged, got %+v", spend)
+	}
+	free.err = errors.New("connection refused")
+	if _, err := b.Get(ctx, "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if spend := b.Spend(); spend.Monthly != 5 || spend.Getters["zen-rows"].Credits != 5 {
+		t.Fatalf("expected CostPerRequest charged for the paid getter, got %+v", spend)
+	}
+}
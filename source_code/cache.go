@@ -0,0 +1,264 @@
+package source_code
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Seann-Moser/cutil/logc"
+	"go.uber.org/zap"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var _ SourceGetter = &Cache{}
+
+// Cache wraps a getter and serves repeated GETs from a CacheStore. Entries live
+// for TTL unless the response's Cache-Control or Expires says otherwise, stale
+// entries with an ETag or Last-Modified are revalidated with a conditional request,
+// and challenge pages are never stored.
+type Cache struct {
+	getter SourceGetter
+	store  CacheStore
+	TTL    time.Duration
+
+	hits        atomic.Int64
+	misses      atomic.Int64
+	revalidated atomic.Int64
+}
+
+type CacheStats struct {
+	Hits        int64
+	Misses      int64
+	Revalidated int64
+}
+
+func NewCache(getter SourceGetter, store CacheStore, ttl time.Duration) *Cache {
+	return &Cache{
+		getter: getter,
+		store:  store,
+		TTL:    ttl,
+	}
+}
+
+func (c *Cache) Get(ctx context.Context, endpoint string, options ...SourceOptions) (*SourceResponse, error) {
+	o := mergeSourceOptions(options...)
+	if o.method() != http.MethodGet || o.Body != nil {
+		return c.getter.Get(ctx, endpoint, options...)
+	}
+	key := cacheKey(endpoint, o)
+	entry, found := c.store.Get(key)
+	if found && entry.fresh(time.Now()) {
+		resp := entry.response()
+		if validate(resp, o.Validators) == nil {
+			c.hits.Add(1)
+			return resp, nil
+		}
+	}
+
+	if found && entry.revalidatable() && c.forwardsHeaders() {
+		// Validators are checked here rather than by the getter, a 304 has no body to validate.
+		conditional := o
+		conditional.Validators = nil
+		conditional.Headers = conditional.Headers.Clone()
+		if conditional.Headers == nil {
+			conditional.Headers = http.Header{}
+		}
+		if entry.ETag != "" {
+			conditional.Headers.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			conditional.Headers.Set("If-Modified-Since", entry.LastModified)
+		}
+		resp, err := c.getter.Get(ctx, endpoint, conditional)
+		if err == nil && resp.StatusCode == http.StatusNotModified {
+			entry.Expires = c.expires(resp.Headers, time.Now())
+			c.put(ctx, entry)
+			cached := entry.response()
+			if err = validate(cached, o.Validators); err == nil {
+				c.revalidated.Add(1)
+			}
+			return cached, err
+		}
+		if err == nil {
+			err = validate(resp, o.Validators)
+		}
+		c.misses.Add(1)
+		c.save(ctx, key, endpoint, resp, err)
+		return resp, err
+	}
+
+	c.misses.Add(1)
+	resp, err := c.getter.Get(ctx, endpoint, options...)
+	c.save(ctx, key, endpoint, resp, err)
+	return resp, err
+}
+
+func (c *Cache) save(ctx context.Context, key, endpoint string, resp *SourceResponse, err error) {
+	if err != nil || resp == nil || resp.StatusCode != http.StatusOK {
+		return
+	}
+	if cacheControl(resp.Headers).has("no-store") {
+		return
+	}
+	if _, challenge := DetectChallenge(resp.StatusCode, resp.Headers, resp.Body); challenge {
+		return
+	}
+	now := time.Now()
+	c.put(ctx, &CacheEntry{
+		Key:          key,
+		URL:          endpoint,
+		FinalURL:     resp.FinalURL,
+		StatusCode:   resp.StatusCode,
+		Headers:      resp.Headers.Clone(),
+		Cookies:      cloneCookies(resp.Cookies),
+		Getter:       resp.Getter,
+		StoredAt:     now,
+		Expires:      c.expires(resp.Headers, now),
+		ETag:         resp.Headers.Get("ETag"),
+		LastModified: resp.Headers.Get("Last-Modified"),
+		Body:         resp.Body,
+	})
+}
+
+func (c *Cache) put(ctx context.Context, entry *CacheEntry) {
+	if err := c.store.Set(entry); err != nil {
+		logc.Warn(ctx, "failed caching response", zap.String("url", entry.URL), zap.Error(err))
+	}
+}
+
+// expires works out how long a response may be served without revalidation.
+// no-cache and max-age=0 responses are stored but revalidated on every use.
+func (c *Cache) expires(headers http.Header, now time.Time) time.Time {
+	cc := cacheControl(headers)
+	if cc.has("no-cache") {
+		return now
+	}
+	if maxAge, found := cc["max-age"]; found {
+		if seconds, err := strconv.Atoi(maxAge); err == nil {
+			return now.Add(time.Duration(seconds) * time.Second)
+		}
+	}
+	if expires := headers.Get("Expires"); expires != "" {
+		if t, err := http.ParseTime(expires); err == nil {
+			return t
+		}
+	}
+	return now.Add(c.TTL)
+}
+
+func (c *Cache) Stats() CacheStats {
+	return CacheStats{
+		Hits:        c.hits.Load(),
+		Misses:      c.misses.Load(),
+		Revalidated: c.revalidated.Load(),
+	}
+}
+
+func (c *Cache) Ping(ctx context.Context) HealthStatus {
+	return c.getter.Ping(ctx)
+}
+
+func (c *Cache) Enabled() bool {
+	return c.getter.Enabled()
+}
+
+func (c *Cache) Name() string {
+	return "cache-" + c.getter.Name()
+}
+
+// forwardsHeaders reports whether conditional headers reach the origin unchanged.
+// Other getters, such as ZenRows, treat request headers as an instruction of their
+// own, so stale entries behind them are fetched again instead.
+func (c *Cache) forwardsHeaders() bool {
+	_, ok := asGetter[*Direct](c.getter)
+	return ok
+}
+
+// Unwrap returns the getter behind the cache.
+func (c *Cache) Unwrap() SourceGetter {
+	return c.getter
+}
+
+func (c *CacheEntry) response() *SourceResponse {
+	return &SourceResponse{
+		Body:       c.Body,
+		StatusCode: c.StatusCode,
+		Headers:    c.Headers.Clone(),
+		Cookies:    cloneCookies(c.Cookies),
+		FinalURL:   c.FinalURL,
+		Getter:     c.Getter,
+		StartedAt:  time.Now(),
+		Cached:     true,
+	}
+}
+
+// cloneCookies copies cookies so callers cannot change a cached entry through a response.
+func cloneCookies(cookies []*http.Cookie) []*http.Cookie {
+	if cookies == nil {
+		return nil
+	}
+	cloned := make([]*http.Cookie, len(cookies))
+	for i, c := range cookies {
+		c := *c
+		cloned[i] = &c
+	}
+	return cloned
+}
+
+// cacheKey identifies a request by URL plus the options that can change the
+// response: headers, cookies, the wait selector, proxy, body limit and ZenRows
+// parameters, which can turn the page into a screenshot or JSON.
+func cacheKey(endpoint string, o SourceOptions) string {
+	parts := []string{endpoint}
+	keys := make([]string, 0, len(o.Headers))
+	for key := range o.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		parts = append(parts, key+"="+strings.Join(o.Headers[key], ","))
+	}
+	if o.UserAgent != "" {
+		parts = append(parts, "User-Agent="+o.UserAgent)
+	}
+	for _, cookie := range o.Cookies {
+		parts = append(parts, "Cookie="+cookie.Name+"="+cookie.Value)
+	}
+	if o.WaitSelector != "" {
+		parts = append(parts, "WaitSelector="+o.WaitSelector)
+	}
+	if o.Proxy != "" {
+		parts = append(parts, "Proxy="+o.Proxy)
+	}
+	if o.MaxBodyBytes > 0 {
+		parts = append(parts, "MaxBodyBytes="+strconv.FormatInt(o.MaxBodyBytes, 10))
+	}
+	if o.ZenRows != nil {
+		// Fields marshal in order and map keys sorted, so equal options share a key.
+		// Options that cannot marshal fail in ZenRows too, so nothing is stored under them.
+		b, _ := json.Marshal(o.ZenRows)
+		parts = append(parts, "ZenRows="+string(b))
+	}
+	return strings.Join(parts, "\n")
+}
+
+type cacheDirectives map[string]string
+
+func cacheControl(headers http.Header) cacheDirectives {
+	directives := cacheDirectives{}
+	for _, value := range headers.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+	return directives
+}
+
+func (c cacheDirectives) has(directive string) bool {
+	_, found := c[directive]
+	return found
+}
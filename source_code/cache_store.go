@@ -0,0 +1,291 @@
+package source_code
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CacheEntry is a stored response along with what is needed to revalidate it.
+type CacheEntry struct {
+	Key          string         `json:"key"`
+	URL          string         `json:"url"`
+	FinalURL     string         `json:"final_url"`
+	StatusCode   int            `json:"status_code"`
+	Headers      http.Header    `json:"headers"`
+	Cookies      []*http.Cookie `json:"cookies,omitempty"`
+	Getter       string         `json:"getter"`
+	StoredAt     time.Time      `json:"stored_at"`
+	Expires      time.Time      `json:"expires"`
+	ETag         string         `json:"etag,omitempty"`
+	LastModified string         `json:"last_modified,omitempty"`
+	BodyHash     string         `json:"body_hash"`
+	Size         int64          `json:"size,omitempty"`
+	Body         []byte         `json:"-"`
+}
+
+func (c *CacheEntry) fresh(now time.Time) bool {
+	return now.Before(c.Expires)
+}
+
+func (c *CacheEntry) revalidatable() bool {
+	return c.ETag != "" || c.LastModified != ""
+}
+
+type CacheStore interface {
+	Get(key string) (*CacheEntry, bool)
+	Set(entry *CacheEntry) error
+	Delete(key string) error
+}
+
+var _ CacheStore = &MemoryCache{}
+var _ CacheStore = &DiskCache{}
+
+// MemoryCache is an LRU CacheStore that evicts the least recently used entries
+// once the stored bodies exceed MaxBytes.
+type MemoryCache struct {
+	MaxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func NewMemoryCache(maxBytes int64) *MemoryCache {
+	return &MemoryCache{
+		MaxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (m *MemoryCache) Get(key string) (*CacheEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, found := m.entries[key]
+	if !found {
+		return nil, false
+	}
+	m.order.MoveToFront(e)
+	entry := *e.Value.(*CacheEntry)
+	return &entry, true
+}
+
+func (m *MemoryCache) Set(entry *CacheEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.MaxBytes > 0 && int64(len(entry.Body)) > m.MaxBytes {
+		return nil
+	}
+	if e, found := m.entries[entry.Key]; found {
+		m.remove(e)
+	}
+	stored := *entry
+	m.entries[entry.Key] = m.order.PushFront(&stored)
+	m.size += int64(len(stored.Body))
+	for m.MaxBytes > 0 && m.size > m.MaxBytes {
+		m.remove(m.order.Back())
+	}
+	return nil
+}
+
+func (m *MemoryCache) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, found := m.entries[key]; found {
+		m.remove(e)
+	}
+	return nil
+}
+
+func (m *MemoryCache) remove(e *list.Element) {
+	entry := m.order.Remove(e).(*CacheEntry)
+	delete(m.entries, entry.Key)
+	m.size -= int64(len(entry.Body))
+}
+
+// DiskCache stores bodies as content-addressed files under Dir/objects and keeps
+// the entry metadata in Dir/index.json. Entries older than MaxAge are dropped and
+// the oldest are evicted once the stored bodies exceed MaxBytes; left at zero the
+// cache grows without limit. index.json is rewritten on every Set and Delete.
+type DiskCache struct {
+	Dir      string
+	MaxBytes int64
+	MaxAge   time.Duration
+
+	mu    sync.Mutex
+	index map[string]*CacheEntry
+}
+
+func NewDiskCache(dir string) (*DiskCache, error) {
+	d := &DiskCache{
+		Dir:   dir,
+		index: make(map[string]*CacheEntry),
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "objects"), 0o755); err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(d.indexPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &d.index); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *DiskCache) indexPath() string {
+	return filepath.Join(d.Dir, "index.json")
+}
+
+func (d *DiskCache) objectPath(hash string) string {
+	return filepath.Join(d.Dir, "objects", hash[:2], hash)
+}
+
+func (d *DiskCache) Get(key string) (*CacheEntry, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, found := d.index[key]
+	if !found || d.expired(e, time.Now()) {
+		return nil, false
+	}
+	body, err := os.ReadFile(d.objectPath(e.BodyHash))
+	if err != nil {
+		delete(d.index, key)
+		return nil, false
+	}
+	entry := *e
+	entry.Body = body
+	return &entry, true
+}
+
+func (d *DiskCache) Set(entry *CacheEntry) error {
+	if d.MaxBytes > 0 && int64(len(entry.Body)) > d.MaxBytes {
+		return nil
+	}
+	sum := sha256.Sum256(entry.Body)
+	hash := hex.EncodeToString(sum[:])
+	path := d.objectPath(hash)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		if err = writeFileAtomic(path, entry.Body); err != nil {
+			return err
+		}
+	}
+	stored := *entry
+	stored.Body = nil
+	stored.BodyHash = hash
+	stored.Size = int64(len(entry.Body))
+	previous := d.index[entry.Key]
+	d.index[entry.Key] = &stored
+	if previous != nil && previous.BodyHash != hash {
+		d.removeObject(previous.BodyHash)
+	}
+	d.evict(time.Now())
+	return d.saveIndex()
+}
+
+func (d *DiskCache) expired(e *CacheEntry, now time.Time) bool {
+	return d.MaxAge > 0 && now.Sub(e.StoredAt) > d.MaxAge
+}
+
+// evict drops expired entries, then the oldest until the bodies fit in MaxBytes.
+// Bodies shared by several entries are counted once.
+func (d *DiskCache) evict(now time.Time) {
+	for key, e := range d.index {
+		if d.expired(e, now) {
+			delete(d.index, key)
+			d.removeObject(e.BodyHash)
+		}
+	}
+	if d.MaxBytes <= 0 {
+		return
+	}
+	refs := make(map[string]int)
+	var size int64
+	entries := make([]*CacheEntry, 0, len(d.index))
+	for _, e := range d.index {
+		if refs[e.BodyHash] == 0 {
+			size += e.Size
+		}
+		refs[e.BodyHash]++
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].StoredAt.Before(entries[j].StoredAt)
+	})
+	for _, e := range entries {
+		if size <= d.MaxBytes {
+			return
+		}
+		delete(d.index, e.Key)
+		if refs[e.BodyHash]--; refs[e.BodyHash] == 0 {
+			size -= e.Size
+			_ = os.Remove(d.objectPath(e.BodyHash))
+		}
+	}
+}
+
+func (d *DiskCache) Delete(key string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, found := d.index[key]
+	if !found {
+		return nil
+	}
+	delete(d.index, key)
+	d.removeObject(e.BodyHash)
+	return d.saveIndex()
+}
+
+// removeObject deletes a body file once no index entry references it.
+func (d *DiskCache) removeObject(hash string) {
+	for _, e := range d.index {
+		if e.BodyHash == hash {
+			return
+		}
+	}
+	_ = os.Remove(d.objectPath(hash))
+}
+
+func (d *DiskCache) saveIndex() error {
+	b, err := json.MarshalIndent(d.index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(d.indexPath(), b)
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,215 @@
+package source_code
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newCacheTestServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		switch r.URL.Path {
+		case "/etag":
+			w.Header().Set("Cache-Control", "max-age=0")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store")
+		case "/challenge":
+			_, _ = w.Write([]byte(`<title>Just a moment...</title>`))
+			return
+		case "/page":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+		}
+		_, _ = w.Write([]byte("page " + r.URL.Path))
+	}))
+	t.Cleanup(server.Close)
+	return server, &hits
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	server, hits := newCacheTestServer(t)
+	d := NewDirect(server.Client())
+	d.enabled.Store(true)
+	c := NewCache(d, NewMemoryCache(1<<20), time.Hour)
+
+	for i := 0; i < 3; i++ {
+		resp, err := c.Get(ctx, server.URL+"/page")
+		if err != nil || string(resp.Body) != "page /page" {
+			t.Fatalf("unexpected response %v %v", resp, err)
+		}
+		if resp.Cached != (i > 0) {
+			t.Fatalf("expected cached %v on call %d", i > 0, i)
+		}
+		if resp.Headers.Get("X-Changed") != "" || len(resp.Cookies) != 1 || resp.Cookies[0].Value != "abc" {
+			t.Fatalf("expected the stored headers and cookies on call %d, got %v %v", i, resp.Headers, resp.Cookies)
+		}
+		resp.Headers.Set("X-Changed", "yes")
+		resp.Cookies[0].Value = "changed"
+	}
+	if hits.Load() != 1 {
+		t.Fatalf("expected 1 upstream request, got %d", hits.Load())
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, err := c.Get(ctx, server.URL+"/etag")
+		if err != nil || string(resp.Body) != "page /etag" {
+			t.Fatalf("unexpected response %v %v", resp, err)
+		}
+	}
+	if stats := c.Stats(); stats.Revalidated != 1 || stats.Hits != 2 {
+		t.Fatalf("expected a revalidated response, got %+v", stats)
+	}
+
+	for _, path := range []string{"/no-store", "/no-store", "/challenge", "/challenge"} {
+		_, _ = c.Get(ctx, server.URL+path)
+	}
+	if hits.Load() != 7 {
+		t.Fatalf("expected no-store and challenge pages to not be cached, got %d upstream requests", hits.Load())
+	}
+}
+
+// headerGetter hides the Direct it wraps, like a getter that rewrites headers would.
+type headerGetter struct {
+	SourceGetter
+	headers http.Header
+}
+
+func (h *headerGetter) Get(ctx context.Context, endpoint string, options ...SourceOptions) (*SourceResponse, error) {
+	h.headers = mergeSourceOptions(options...).Headers
+	return h.SourceGetter.Get(ctx, endpoint, options...)
+}
+
+func TestCacheKeyOptions(t *testing.T) {
+	ctx := context.Background()
+	server, hits := newCacheTestServer(t)
+	d := NewDirect(server.Client())
+	d.enabled.Store(true)
+	c := NewCache(d, NewMemoryCache(1<<20), time.Hour)
+
+	for _, o := range []SourceOptions{
+		{},
+		{ZenRows: &ZenRowsOptions{Screenshot: Bool(true)}},
+		{ZenRows: &ZenRowsOptions{CSSExtractor: map[string]string{"title": "h1", "links": "a"}}},
+		{WaitSelector: "#main"},
+		{Proxy: server.URL},
+		{MaxBodyBytes: 1 << 20},
+	} {
+		if _, err := c.Get(ctx, server.URL+"/page", o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hits.Load() != 6 {
+		t.Fatalf("expected each option set to miss the cache, got %d upstream requests", hits.Load())
+	}
+	resp, err := c.Get(ctx, server.URL+"/page", SourceOptions{ZenRows: &ZenRowsOptions{CSSExtractor: map[string]string{"links": "a", "title": "h1"}}})
+	if err != nil || !resp.Cached {
+		t.Fatalf("expected equal ZenRows options to share an entry, got %v %v", resp, err)
+	}
+
+	opaque := &headerGetter{SourceGetter: d}
+	c = NewCache(opaque, NewMemoryCache(1<<20), time.Hour)
+	for i := 0; i < 2; i++ {
+		if _, err = c.Get(ctx, server.URL+"/etag"); err != nil {
+			t.Fatal(err)
+		}
+		if opaque.headers.Get("If-None-Match") != "" {
+			t.Fatalf("expected no conditional headers through a getter that may rewrite them, got %v", opaque.headers)
+		}
+	}
+	if stats := c.Stats(); stats.Revalidated != 0 || stats.Misses != 2 {
+		t.Fatalf("expected stale entries to be fetched again, got %+v", stats)
+	}
+}
+
+func TestMemoryCacheEviction(t *testing.T) {
+	m := NewMemoryCache(10)
+	_ = m.Set(&CacheEntry{Key: "a", Body: []byte("12345")})
+	_ = m.Set(&CacheEntry{Key: "b", Body: []byte("12345")})
+	m.Get("a")
+	_ = m.Set(&CacheEntry{Key: "c", Body: []byte("12345")})
+	if _, found := m.Get("b"); found {
+		t.Fatal("expected least recently used entry to be evicted")
+	}
+	if _, found := m.Get("a"); !found {
+		t.Fatal("expected recently used entry to be kept")
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	server, hits := newCacheTestServer(t)
+	d := NewDirect(server.Client())
+	d.enabled.Store(true)
+
+	store, err := NewDiskCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewCache(d, store, time.Hour).Get(ctx, server.URL+"/page"); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewDiskCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := NewCache(d, reopened, time.Hour).Get(ctx, server.URL+"/page")
+	if err != nil || !resp.Cached || !strings.Contains(string(resp.Body), "/page") {
+		t.Fatalf("expected response from disk, got %v %v", resp, err)
+	}
+	if hits.Load() != 1 {
+		t.Fatalf("expected 1 upstream request, got %d", hits.Load())
+	}
+	if err = reopened.Delete(cacheKey(server.URL+"/page", SourceOptions{})); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := reopened.Get(cacheKey(server.URL+"/page", SourceOptions{})); found {
+		t.Fatal("expected entry to be deleted")
+	}
+}
+
+func TestDiskCacheEviction(t *testing.T) {
+	store, err := NewDiskCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.MaxBytes = 10
+	store.MaxAge = time.Hour
+	now := time.Now()
+	_ = store.Set(&CacheEntry{Key: "old", Body: []byte("old"), StoredAt: now.Add(-2 * time.Hour)})
+	_ = store.Set(&CacheEntry{Key: "a", Body: []byte("12345"), StoredAt: now.Add(-time.Minute)})
+	_ = store.Set(&CacheEntry{Key: "shared", Body: []byte("12345"), StoredAt: now.Add(-time.Minute)})
+	if _, found := store.Get("old"); found {
+		t.Fatal("expected an entry older than MaxAge to be dropped")
+	}
+	_ = store.Set(&CacheEntry{Key: "b", Body: []byte("abcde"), StoredAt: now})
+	if _, found := store.Get("a"); !found {
+		t.Fatal("expected a shared body to be counted once")
+	}
+	_ = store.Set(&CacheEntry{Key: "c", Body: []byte("vwxyz"), StoredAt: now})
+	for key, want := range map[string]bool{"a": false, "shared": false, "b": true, "c": true} {
+		if _, found := store.Get(key); found != want {
+			t.Fatalf("expected %s found %v", key, want)
+		}
+	}
+	sum := sha256.Sum256([]byte("12345"))
+	if _, err = os.Stat(store.objectPath(hex.EncodeToString(sum[:]))); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the evicted body removed, got %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package source_code
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"sync"
+)
+
+// cdpConn is a minimal Chrome DevTools Protocol client over a browser websocket,
+// using flattened sessions so page commands share the one connection.
+type cdpConn struct {
+	ws *websocket.Conn
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	nextID  int64
+	pending map[int64]chan cdpMessage
+	events  map[string][]*cdpEvents
+	err     error
+	done    chan struct{}
+}
+
+type cdpMessage struct {
+	ID        int64           `json:"id,omitempty"`
+	SessionID string          `json:"sessionId,omitempty"`
+	Method    string          `json:"method,omitempty"`
+	Params    json.RawMessage `json:"params,omitempty"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     *cdpError       `json:"error,omitempty"`
+}
+
+type cdpError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (c *cdpError) Error() string {
+	return fmt.Sprintf("cdp error %d: %s", c.Code, c.Message)
+}
+
+func dialCDP(ctx context.Context, wsURL string) (*cdpConn, error) {
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, wsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	c := &cdpConn{
+		ws:      ws,
+		pending: make(map[int64]chan cdpMessage),
+		events:  make(map[string][]*cdpEvents),
+		done:    make(chan struct{}),
+	}
+	go c.read()
+	return c, nil
+}
+
+func (c *cdpConn) read() {
+	defer close(c.done)
+	for {
+		msg := cdpMessage{}
+		if err := c.ws.ReadJSON(&msg); err != nil {
+			c.mu.Lock()
+			c.err = err
+			c.mu.Unlock()
+			return
+		}
+		c.mu.Lock()
+		if msg.ID != 0 {
+			if ch, found := c.pending[msg.ID]; found {
+				delete(c.pending, msg.ID)
+				ch <- msg
+			}
+		} else {
+			for _, events := range c.events[msg.SessionID+"/"+msg.Method] {
+				events.push(msg)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// subscribe queues the session's events for methods in the order they were
+// received. The queue is unbounded, so the reader never blocks on a slow page and
+// no event, such as Page.loadEventFired, is lost behind a burst of requests.
+func (c *cdpConn) subscribe(sessionID string, methods ...string) *cdpEvents {
+	events := &cdpEvents{ready: make(chan struct{}, 1)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, method := range methods {
+		key := sessionID + "/" + method
+		c.events[key] = append(c.events[key], events)
+	}
+	return events
+}
+
+// cdpEvents is a subscription's queue. Ready is signalled whenever events are
+// waiting; take returns them all.
+type cdpEvents struct {
+	mu    sync.Mutex
+	queue []cdpMessage
+	ready chan struct{}
+}
+
+func (e *cdpEvents) push(msg cdpMessage) {
+	e.mu.Lock()
+	e.queue = append(e.queue, msg)
+	e.mu.Unlock()
+	select {
+	case e.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (e *cdpEvents) Ready() <-chan struct{} {
+	return e.ready
+}
+
+func (e *cdpEvents) take() []cdpMessage {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	queue := e.queue
+	e.queue = nil
+	return queue
+}
+
+func (c *cdpConn) call(ctx context.Context, sessionID, method string, params interface{}, result interface{}) error {
+	ch := make(chan cdpMessage, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	msg := cdpMessage{ID: id, SessionID: sessionID, Method: method}
+	if params != nil {
+		b, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		msg.Params = b
+	}
+	c.writeMu.Lock()
+	err := c.ws.WriteJSON(msg)
+	c.writeMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return fmt.Errorf("%s: %w", method, resp.Error)
+		}
+		if result == nil || len(resp.Result) == 0 {
+			return nil
+		}
+		return json.Unmarshal(resp.Result, result)
+	case <-c.done:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return fmt.Errorf("%s: %w", method, c.err)
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (c *cdpConn) Close() error {
+	return c.ws.Close()
+}
@@ -1,20 +1,248 @@
 package source_code
 
-import "regexp"
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+)
 
-var regexChallange = []*regexp.Regexp{
-	regexp.MustCompile(`<title>.*Just a moment.*</title>`),
-	regexp.MustCompile(`id="challenge-error-title"`),
-	regexp.MustCompile(`id="challenge-error-text"`),
-	regexp.MustCompile(`id="challenge-error"`),
-	regexp.MustCompile(`challenge-platform`),
+type ChallengeVendor string
+
+const (
+	VendorCloudflare ChallengeVendor = "cloudflare"
+	VendorDataDome   ChallengeVendor = "datadome"
+	VendorPerimeterX ChallengeVendor = "perimeterx"
+	VendorAkamai     ChallengeVendor = "akamai"
+	VendorHCaptcha   ChallengeVendor = "hcaptcha"
+	VendorReCaptcha  ChallengeVendor = "recaptcha"
+	VendorGeneric    ChallengeVendor = "generic"
+)
+
+type ChallengeKind string
+
+const (
+	ChallengeKindJS      ChallengeKind = "js-challenge"
+	ChallengeKindCaptcha ChallengeKind = "captcha"
+	ChallengeKindBlock   ChallengeKind = "block"
+)
+
+// ChallengeThreshold is the minimum confidence DetectChallenge reports a challenge at.
+var ChallengeThreshold = 0.5
+
+type ChallengeInfo struct {
+	Vendor     ChallengeVendor
+	Kind       ChallengeKind
+	Confidence float64
+	Signal     string
 }
 
-func HasChallange(data []byte) bool {
-	for _, rc := range regexChallange {
-		if rc.Match(data) {
+type ChallengeDetector interface {
+	Detect(statusCode int, headers http.Header, body []byte) (*ChallengeInfo, bool)
+}
+
+// ChallengeError is returned by getters when the response is a challenge page.
+// It matches HasChallengeErr with errors.Is.
+type ChallengeError struct {
+	Info ChallengeInfo
+}
+
+func (c *ChallengeError) Error() string {
+	return fmt.Sprintf("%s: %s %s (%s)", HasChallengeErr, c.Info.Vendor, c.Info.Kind, c.Info.Signal)
+}
+
+func (c *ChallengeError) Unwrap() error {
+	return HasChallengeErr
+}
+
+// ChallengeHandler is implemented by getters that know whether they can get past a
+// vendor's challenge. Fallback tries handlers of a detected vendor first.
+type ChallengeHandler interface {
+	HandlesChallenge(info ChallengeInfo) bool
+}
+
+// Signal is a single piece of evidence for a challenge. Pattern matches the body,
+// or the value of Header when set. StatusCodes, when set, limit the status codes
+// the signal counts for.
+type Signal struct {
+	Name        string
+	Header      string
+	Pattern     *regexp.Regexp
+	StatusCodes []int
+	Confidence  float64
+}
+
+func (s Signal) match(statusCode int, headers http.Header, body []byte) bool {
+	if len(s.StatusCodes) > 0 {
+		found := false
+		for _, code := range s.StatusCodes {
+			if code == statusCode {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if s.Header == "" {
+		return s.Pattern.Match(body)
+	}
+	for _, value := range headers.Values(s.Header) {
+		if s.Pattern.MatchString(value) {
 			return true
 		}
 	}
 	return false
 }
+
+// SignalDetector reports its vendor when any of its signals match, with the
+// confidence of the strongest one.
+type SignalDetector struct {
+	Vendor  ChallengeVendor
+	Kind    ChallengeKind
+	Signals []Signal
+}
+
+var _ ChallengeDetector = &SignalDetector{}
+
+func (s *SignalDetector) Detect(statusCode int, headers http.Header, body []byte) (*ChallengeInfo, bool) {
+	var info *ChallengeInfo
+	for _, signal := range s.Signals {
+		if !signal.match(statusCode, headers, body) {
+			continue
+		}
+		if info == nil || signal.Confidence > info.Confidence {
+			info = &ChallengeInfo{
+				Vendor:     s.Vendor,
+				Kind:       s.Kind,
+				Confidence: signal.Confidence,
+				Signal:     signal.Name,
+			}
+		}
+	}
+	return info, info != nil
+}
+
+var blockedStatusCodes = []int{http.StatusForbidden, http.StatusTooManyRequests, http.StatusServiceUnavailable}
+
+var (
+	CloudflareDetector = &SignalDetector{
+		Vendor: VendorCloudflare,
+		Kind:   ChallengeKindJS,
+		Signals: []Signal{
+			{Name: "cf-mitigated header", Header: "cf-mitigated", Pattern: regexp.MustCompile(`(?i)challenge`), Confidence: 1},
+			{Name: "just a moment title", Pattern: regexp.MustCompile(`<title>.*Just a moment.*</title>`), Confidence: 0.9},
+			{Name: "challenge-error-title", Pattern: regexp.MustCompile(`id="challenge-error-title"`), Confidence: 0.9},
+			{Name: "challenge-error-text", Pattern: regexp.MustCompile(`id="challenge-error-text"`), Confidence: 0.9},
+			{Name: "challenge-error", Pattern: regexp.MustCompile(`id="challenge-error"`), Confidence: 0.9},
+			{Name: "challenge-platform", Pattern: regexp.MustCompile(`challenge-platform`), Confidence: 0.8},
+			{Name: "cloudflare server blocked", Header: "Server", Pattern: regexp.MustCompile(`(?i)^cloudflare`), StatusCodes: blockedStatusCodes, Confidence: 0.6},
+		},
+	}
+	DataDomeDetector = &SignalDetector{
+		Vendor: VendorDataDome,
+		Kind:   ChallengeKindCaptcha,
+		Signals: []Signal{
+			{Name: "captcha-delivery", Pattern: regexp.MustCompile(`(?:geo|ct)\.captcha-delivery\.com`), Confidence: 0.95},
+			{Name: "x-datadome header", Header: "X-Datadome", Pattern: regexp.MustCompile(`.+`), StatusCodes: blockedStatusCodes, Confidence: 0.9},
+			{Name: "datadome cookie", Header: "Set-Cookie", Pattern: regexp.MustCompile(`^datadome=`), StatusCodes: blockedStatusCodes, Confidence: 0.8},
+		},
+	}
+	PerimeterXDetector = &SignalDetector{
+		Vendor: VendorPerimeterX,
+		Kind:   ChallengeKindCaptcha,
+		Signals: []Signal{
+			{Name: "px-captcha", Pattern: regexp.MustCompile(`id="px-captcha"`), Confidence: 0.95},
+			{Name: "px captcha script", Pattern: regexp.MustCompile(`captcha\.px-cdn\.net`), StatusCodes: blockedStatusCodes, Confidence: 0.9},
+			{Name: "press and hold", Pattern: regexp.MustCompile(`(?i)press\s*(?:&amp;|&)\s*hold`), StatusCodes: blockedStatusCodes, Confidence: 0.8},
+			{Name: "px app id", Pattern: regexp.MustCompile(`window\._pxAppId`), StatusCodes: blockedStatusCodes, Confidence: 0.8},
+		},
+	}
+	AkamaiDetector = &SignalDetector{
+		Vendor: VendorAkamai,
+		Kind:   ChallengeKindBlock,
+		Signals: []Signal{
+			{Name: "edgesuite reference", Pattern: regexp.MustCompile(`errors\.edgesuite\.net|Reference&#32;&#35;[0-9a-f.]+`), Confidence: 0.9},
+			{Name: "akamai bot manager", Pattern: regexp.MustCompile(`/_sec/cp_challenge/|bm-verify`), Confidence: 0.85},
+			{Name: "akamai server blocked", Header: "Server", Pattern: regexp.MustCompile(`(?i)^AkamaiGHost`), StatusCodes: blockedStatusCodes, Confidence: 0.8},
+		},
+	}
+	HCaptchaDetector = &SignalDetector{
+		Vendor: VendorHCaptcha,
+		Kind:   ChallengeKindCaptcha,
+		Signals: []Signal{
+			{Name: "hcaptcha blocked", Pattern: regexp.MustCompile(`hcaptcha\.com/1/api\.js|class="h-captcha"`), StatusCodes: blockedStatusCodes, Confidence: 0.8},
+		},
+	}
+	ReCaptchaDetector = &SignalDetector{
+		Vendor: VendorReCaptcha,
+		Kind:   ChallengeKindCaptcha,
+		Signals: []Signal{
+			{Name: "recaptcha blocked", Pattern: regexp.MustCompile(`google\.com/recaptcha/api\.js|class="g-recaptcha"`), StatusCodes: blockedStatusCodes, Confidence: 0.8},
+		},
+	}
+	AccessDeniedDetector = &SignalDetector{
+		Vendor: VendorGeneric,
+		Kind:   ChallengeKindBlock,
+		Signals: []Signal{
+			{Name: "access denied blocked", Pattern: regexp.MustCompile(`(?i)<title>\s*(?:access denied|403 forbidden|attention required|you have been blocked|request blocked)`), StatusCodes: blockedStatusCodes, Confidence: 0.7},
+		},
+	}
+)
+
+var (
+	detectorsMu sync.RWMutex
+	detectors   = []ChallengeDetector{
+		CloudflareDetector,
+		DataDomeDetector,
+		PerimeterXDetector,
+		AkamaiDetector,
+		HCaptchaDetector,
+		ReCaptchaDetector,
+		AccessDeniedDetector,
+	}
+)
+
+// RegisterChallengeDetector adds a detector consulted by DetectChallenge after the built-in ones.
+func RegisterChallengeDetector(detector ChallengeDetector) {
+	detectorsMu.Lock()
+	defer detectorsMu.Unlock()
+	detectors = append(detectors, detector)
+}
+
+func ChallengeDetectors() []ChallengeDetector {
+	detectorsMu.RLock()
+	defer detectorsMu.RUnlock()
+	return append([]ChallengeDetector(nil), detectors...)
+}
+
+// DetectChallenge runs every registered detector and returns the most confident
+// match at or above ChallengeThreshold.
+func DetectChallenge(statusCode int, headers http.Header, body []byte) (*ChallengeInfo, bool) {
+	var best *ChallengeInfo
+	for _, detector := range ChallengeDetectors() {
+		info, found := detector.Detect(statusCode, headers, body)
+		if !found || info.Confidence < ChallengeThreshold {
+			continue
+		}
+		if best == nil || info.Confidence > best.Confidence {
+			best = info
+		}
+	}
+	return best, best != nil
+}
+
+// detectChallengeErr returns a *ChallengeError when the response is a challenge page.
+func detectChallengeErr(statusCode int, headers http.Header, body []byte) error {
+	info, found := DetectChallenge(statusCode, headers, body)
+	if !found {
+		return nil
+	}
+	return &ChallengeError{Info: *info}
+}
+
+func HasChallange(data []byte) bool {
+	_, found := DetectChallenge(0, nil, data)
+	return found
+}
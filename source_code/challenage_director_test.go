@@ -0,0 +1,92 @@
+package source_code
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"testing"
+)
+
+func TestDetectChallenge(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		headers    http.Header
+		body       string
+		vendor     ChallengeVendor
+		found      bool
+	}{
+		{name: "cloudflare body", statusCode: 403, body: `<title>Just a moment...</title>`, vendor: VendorCloudflare, found: true},
+		{name: "cloudflare header", statusCode: 403, headers: http.Header{"Cf-Mitigated": {"challenge"}}, vendor: VendorCloudflare, found: true},
+		{name: "cloudflare server ok", statusCode: 200, headers: http.Header{"Server": {"cloudflare"}}, body: "<html></html>"},
+		{name: "cloudflare server blocked", statusCode: 503, headers: http.Header{"Server": {"cloudflare"}}, vendor: VendorCloudflare, found: true},
+		{name: "datadome", statusCode: 403, body: `<script src="https://ct.captcha-delivery.com/c.js"></script>`, vendor: VendorDataDome, found: true},
+		{name: "perimeterx", statusCode: 403, body: `<div id="px-captcha"></div>`, vendor: VendorPerimeterX, found: true},
+		{name: "perimeterx press and hold", statusCode: 403, body: `<p>Press &amp; Hold to confirm you are a human</p>`, vendor: VendorPerimeterX, found: true},
+		{name: "press and hold ok", statusCode: 200, body: `<p>Press &amp; hold the button to record</p><script src="/captcha/captcha.js"></script>`},
+		{name: "akamai", statusCode: 403, headers: http.Header{"Server": {"AkamaiGHost"}}, body: "Access Denied", vendor: VendorAkamai, found: true},
+		{name: "hcaptcha blocked", statusCode: 403, body: `<div class="h-captcha"></div>`, vendor: VendorHCaptcha, found: true},
+		{name: "recaptcha login form", statusCode: 200, body: `<form><div class="g-recaptcha"></div></form>`},
+		{name: "access denied", statusCode: 403, body: `<title>Access Denied</title>`, vendor: VendorGeneric, found: true},
+		{name: "plain page", statusCode: 200, body: `<title>Products</title>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, found := DetectChallenge(tt.statusCode, tt.headers, []byte(tt.body))
+			if found != tt.found {
+				t.Fatalf("expected found %v, got %v (%+v)", tt.found, found, info)
+			}
+			if found && info.Vendor != tt.vendor {
+				t.Fatalf("expected vendor %s, got %+v", tt.vendor, info)
+			}
+		})
+	}
+}
+
+func TestRegisterChallengeDetector(t *testing.T) {
+	previous := ChallengeDetectors()
+	defer func() {
+		detectorsMu.Lock()
+		detectors = previous
+		detectorsMu.Unlock()
+	}()
+	RegisterChallengeDetector(&SignalDetector{
+		Vendor:  "custom",
+		Kind:    ChallengeKindBlock,
+		Signals: []Signal{{Name: "custom block", Pattern: regexp.MustCompile(`blocked-by-custom`), Confidence: 1}},
+	})
+	info, found := DetectChallenge(200, nil, []byte("blocked-by-custom"))
+	if !found || info.Vendor != "custom" {
+		t.Fatalf("expected custom detector, got %+v", info)
+	}
+}
+
+var _ ChallengeHandler = &handlerGetter{}
+
+type handlerGetter struct {
+	*stubGetter
+	vendor ChallengeVendor
+}
+
+func (h *handlerGetter) HandlesChallenge(info ChallengeInfo) bool {
+	return info.Vendor == h.vendor
+}
+
+func TestFallbackPrefersChallengeHandler(t *testing.T) {
+	ctx := context.Background()
+	challenged := &stubGetter{name: "direct", err: &ChallengeError{Info: ChallengeInfo{Vendor: VendorDataDome}}}
+	cloudflare := &handlerGetter{stubGetter: &stubGetter{name: "cloudflare-only"}, vendor: VendorCloudflare}
+	datadome := &handlerGetter{stubGetter: &stubGetter{name: "datadome"}, vendor: VendorDataDome}
+
+	resp, err := NewFallback(challenged, cloudflare, datadome).Get(ctx, "https://example.com")
+	if err != nil || resp.Getter != "datadome" {
+		t.Fatalf("expected datadome handler to serve, got %v %v", resp, err)
+	}
+	if cloudflare.gets.Load() != 0 {
+		t.Fatal("expected getter that cannot handle the vendor to be skipped")
+	}
+	if !errors.Is(challenged.err, HasChallengeErr) {
+		t.Fatal("expected ChallengeError to match HasChallengeErr")
+	}
+}
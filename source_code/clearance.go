@@ -0,0 +1,90 @@
+package source_code
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+var DefaultClearanceTTL = 30 * time.Minute
+
+// Clearance is what a solver learned about getting past a host's challenge: the
+// cookies it was given and the user agent they are bound to.
+type Clearance struct {
+	UserAgent string
+	Cookies   []*http.Cookie
+	Expires   time.Time
+}
+
+// ClearanceStore shares clearances between getters, typically a FlareSolver that
+// solves a host's challenge once and a Direct that reuses the result.
+type ClearanceStore struct {
+	TTL time.Duration
+
+	mu         sync.Mutex
+	clearances map[string]Clearance
+}
+
+func NewClearanceStore() *ClearanceStore {
+	return &ClearanceStore{
+		TTL:        DefaultClearanceTTL,
+		clearances: make(map[string]Clearance),
+	}
+}
+
+// Set stores the clearance for endpoint's host. It expires at the earliest cookie
+// expiry or after TTL, whichever is sooner.
+func (c *ClearanceStore) Set(endpoint string, clearance Clearance) {
+	if clearance.Expires.IsZero() {
+		clearance.Expires = time.Now().Add(c.TTL)
+		for _, cookie := range clearance.Cookies {
+			if !cookie.Expires.IsZero() && cookie.Expires.Before(clearance.Expires) {
+				clearance.Expires = cookie.Expires
+			}
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.clearances == nil {
+		c.clearances = make(map[string]Clearance)
+	}
+	c.clearances[routeHost(endpoint)] = clearance
+}
+
+func (c *ClearanceStore) Get(endpoint string) (Clearance, bool) {
+	host := routeHost(endpoint)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clearance, found := c.clearances[host]
+	if !found {
+		return Clearance{}, false
+	}
+	if time.Now().After(clearance.Expires) {
+		delete(c.clearances, host)
+		return Clearance{}, false
+	}
+	return clearance, true
+}
+
+func (c *ClearanceStore) Delete(endpoint string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.clearances, routeHost(endpoint))
+}
+
+// apply adds the clearance for r's host, leaving any user agent or cookie the
+// caller already set alone.
+func (c *ClearanceStore) apply(r *http.Request) {
+	clearance, found := c.Get(r.URL.String())
+	if !found {
+		return
+	}
+	if clearance.UserAgent != "" && r.Header.Get("User-Agent") == "" {
+		r.Header.Set("User-Agent", clearance.UserAgent)
+	}
+	for _, cookie := range clearance.Cookies {
+		if _, err := r.Cookie(cookie.Name); err != nil {
+			r.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+		}
+	}
+}
@@ -0,0 +1,546 @@
+package source_code
+
+import (
+	"fmt"
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GetterConfig declares a getter and the wrappers around it, so a pipeline can be
+// described in YAML, JSON or any viper source. Type picks the factory and Options
+// are handed to it; a "fallback" takes its chain from Getters. Wrappers apply from
+// the inside out in field order: request defaults (Retry, Timeout, Headers,
+// UserAgent), Validators, Proxies, RateLimit, Robots, Budget and finally Cache.
+//
+//	getter:
+//	  type: fallback
+//	  fallback: {strategy: cost, router_path: routes.json}
+//	  cache: {ttl: 1h, dir: /var/cache/wp}
+//	  getters:
+//	    - type: direct
+//	      options: {profile: chrome}
+//	      rate_limit: {rps: 1}
+//	    - type: zenrows
+//	      options: {api_key: KEY, premium_proxy: true}
+//	      budget: {monthly: 250000, path: budget.json}
+type GetterConfig struct {
+	Type     string          `mapstructure:"type"`
+	Options  map[string]any  `mapstructure:"options"`
+	Getters  []GetterConfig  `mapstructure:"getters"`
+	Fallback *FallbackConfig `mapstructure:"fallback"`
+
+	Retry      *RetryConfig      `mapstructure:"retry"`
+	Timeout    time.Duration     `mapstructure:"timeout"`
+	Headers    map[string]string `mapstructure:"headers"`
+	UserAgent  string            `mapstructure:"user_agent"`
+	Validators []ValidatorConfig `mapstructure:"validators"`
+	Proxies    *ProxyPoolConfig  `mapstructure:"proxies"`
+	RateLimit  *RateLimitConfig  `mapstructure:"rate_limit"`
+	Robots     *RobotsConfig     `mapstructure:"robots"`
+	Budget     *BudgetConfig     `mapstructure:"budget"`
+	Cache      *CacheConfig      `mapstructure:"cache"`
+}
+
+type FallbackConfig struct {
+	// Strategy is ordered, weighted or cost; Race calls every getter at once.
+	Strategy         string             `mapstructure:"strategy"`
+	Race             bool               `mapstructure:"race"`
+	Weights          map[string]float64 `mapstructure:"weights"`
+	Costs            map[string]float64 `mapstructure:"costs"`
+	PingTTL          time.Duration      `mapstructure:"ping_ttl"`
+	FailureThreshold int                `mapstructure:"failure_threshold"`
+	Cooldown         time.Duration      `mapstructure:"cooldown"`
+	RouterPath       string             `mapstructure:"router_path"`
+	RouteTTL         time.Duration      `mapstructure:"route_ttl"`
+}
+
+type RetryConfig struct {
+	MaxRetry        uint64        `mapstructure:"max_retry"`
+	InitialInterval time.Duration `mapstructure:"initial_interval"`
+	MaxInterval     time.Duration `mapstructure:"max_interval"`
+	MaxElapsedTime  time.Duration `mapstructure:"max_elapsed_time"`
+}
+
+// ValidatorConfig names a built-in validator: min_body_size (Min), require_selector,
+// require_pattern and forbid_pattern (Value), content_type (Values) and status_range (Min, Max).
+type ValidatorConfig struct {
+	Type   string   `mapstructure:"type"`
+	Value  string   `mapstructure:"value"`
+	Values []string `mapstructure:"values"`
+	Min    int      `mapstructure:"min"`
+	Max    int      `mapstructure:"max"`
+}
+
+type ProxyPoolConfig struct {
+	Proxies     []string      `mapstructure:"proxies"`
+	File        string        `mapstructure:"file"`
+	Rotation    string        `mapstructure:"rotation"`
+	MinRequests int           `mapstructure:"min_requests"`
+	MinScore    float64       `mapstructure:"min_score"`
+	Cooldown    time.Duration `mapstructure:"cooldown"`
+}
+
+type RateLimitConfig struct {
+	RequestsPerSecond float64       `mapstructure:"rps"`
+	Burst             int           `mapstructure:"burst"`
+	MaxConcurrent     int           `mapstructure:"max_concurrent"`
+	MinDelay          time.Duration `mapstructure:"min_delay"`
+	Jitter            time.Duration `mapstructure:"jitter"`
+	MaxSlowdown       float64       `mapstructure:"max_slowdown"`
+}
+
+type RobotsConfig struct {
+	UserAgent    string        `mapstructure:"user_agent"`
+	TTL          time.Duration `mapstructure:"ttl"`
+	AllowOnError bool          `mapstructure:"allow_on_error"`
+}
+
+type BudgetConfig struct {
+	Daily          float64 `mapstructure:"daily"`
+	Monthly        float64 `mapstructure:"monthly"`
+	CostPerRequest float64 `mapstructure:"cost_per_request"`
+	Path           string  `mapstructure:"path"`
+}
+
+// CacheConfig stores on disk under Dir, or in memory, bounded by MaxBytes.
+// MaxAge only applies on disk; memory entries are evicted by size alone.
+type CacheConfig struct {
+	TTL      time.Duration `mapstructure:"ttl"`
+	Dir      string        `mapstructure:"dir"`
+	MaxBytes int64         `mapstructure:"max_bytes"`
+	MaxAge   time.Duration `mapstructure:"max_age"`
+}
+
+// GetterFactory builds the getter for a GetterConfig of its registered type,
+// usually decoding cfg.Options with DecodeOptions. Wrappers are applied afterwards.
+type GetterFactory func(client *http.Client, cfg GetterConfig) (SourceGetter, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]GetterFactory{}
+)
+
+// The built-in factories are registered in init since fallback builds its
+// getters through the registry.
+func init() {
+	RegisterGetter("direct", directFactory)
+	RegisterGetter("zenrows", zenRowsFactory)
+	RegisterGetter("flaresolver", flareSolverFactory)
+	RegisterGetter("browser", browserFactory)
+	RegisterGetter("replayer", replayerFactory)
+	RegisterGetter("fallback", fallbackFactory)
+}
+
+// RegisterGetter makes a getter type available to configs, replacing any factory of the same name.
+func RegisterGetter(typ string, factory GetterFactory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[strings.ToLower(typ)] = factory
+}
+
+func GetterTypes() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	types := make([]string, 0, len(factories))
+	for typ := range factories {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// FromConfig builds the getter described under key in v.
+func FromConfig(client *http.Client, v *viper.Viper, key string) (SourceGetter, error) {
+	cfg := GetterConfig{}
+	if err := v.UnmarshalKey(key, &cfg); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", key, err)
+	}
+	return cfg.Build(client)
+}
+
+// FromConfigFile reads a YAML or JSON file, picked by extension, and builds the getter under key.
+func FromConfigFile(client *http.Client, path, key string) (SourceGetter, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	return FromConfig(client, v, key)
+}
+
+// DecodeOptions decodes cfg.Options into target, which uses mapstructure tags.
+func (cfg GetterConfig) DecodeOptions(target any) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:           target,
+		WeaklyTypedInput: true,
+		ErrorUnused:      true,
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+	})
+	if err != nil {
+		return err
+	}
+	if err = decoder.Decode(cfg.Options); err != nil {
+		return fmt.Errorf("%s options: %w", cfg.Type, err)
+	}
+	return nil
+}
+
+func (cfg GetterConfig) Build(client *http.Client) (SourceGetter, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	factoriesMu.RLock()
+	factory, found := factories[strings.ToLower(cfg.Type)]
+	factoriesMu.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("unknown getter type %q, expected one of %s", cfg.Type, strings.Join(GetterTypes(), ", "))
+	}
+	getter, err := factory(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.wrap(getter)
+}
+
+func (cfg GetterConfig) wrap(getter SourceGetter) (SourceGetter, error) {
+	var middlewares []Middleware
+	if cfg.Timeout > 0 {
+		middlewares = append(middlewares, Timeout(cfg.Timeout))
+	}
+	if r := cfg.Retry; r != nil {
+		middlewares = append(middlewares, Retry(NewBackOff(r.MaxRetry, r.InitialInterval, r.MaxInterval, r.MaxElapsedTime)))
+	}
+	if len(cfg.Headers) > 0 || cfg.UserAgent != "" {
+		defaults := SourceOptions{UserAgent: cfg.UserAgent}
+		for key, value := range cfg.Headers {
+			if defaults.Headers == nil {
+				defaults.Headers = http.Header{}
+			}
+			defaults.Headers.Set(key, value)
+		}
+		middlewares = append(middlewares, Defaults(defaults))
+	}
+	getter = Chain(getter, middlewares...)
+	if len(cfg.Validators) > 0 {
+		validators := make([]Validator, 0, len(cfg.Validators))
+		for _, vc := range cfg.Validators {
+			validator, err := vc.build()
+			if err != nil {
+				return nil, err
+			}
+			validators = append(validators, validator)
+		}
+		getter = NewValidated(getter, validators...)
+	}
+	if p := cfg.Proxies; p != nil {
+		proxies := p.Proxies
+		if p.File != "" {
+			loaded, err := LoadProxies(p.File)
+			if err != nil {
+				return nil, err
+			}
+			proxies = append(proxies, loaded...)
+		}
+		pool, err := NewProxyPool(getter, ProxyRotation(p.Rotation), proxies...)
+		if err != nil {
+			return nil, err
+		}
+		if p.MinRequests > 0 {
+			pool.MinRequests = p.MinRequests
+		}
+		if p.MinScore > 0 {
+			pool.MinScore = p.MinScore
+		}
+		pool.Cooldown = p.Cooldown
+		getter = pool
+	}
+	if l := cfg.RateLimit; l != nil {
+		getter = NewRateLimited(getter, RateLimit{
+			RequestsPerSecond: l.RequestsPerSecond,
+			Burst:             l.Burst,
+			MaxConcurrent:     l.MaxConcurrent,
+			MinDelay:          l.MinDelay,
+			Jitter:            l.Jitter,
+			MaxSlowdown:       l.MaxSlowdown,
+		})
+	}
+	if r := cfg.Robots; r != nil {
+		robots := NewRobots(getter, r.UserAgent)
+		if r.TTL > 0 {
+			robots.TTL = r.TTL
+		}
+		robots.AllowOnError = r.AllowOnError
+		getter = robots
+	}
+	if b := cfg.Budget; b != nil {
+		budgeted, err := NewBudgetedFromFile(getter, b.Path, b.Daily, b.Monthly)
+		if err != nil {
+			return nil, err
+		}
+		budgeted.CostPerRequest = b.CostPerRequest
+		getter = budgeted
+	}
+	if c := cfg.Cache; c != nil {
+		var store CacheStore = NewMemoryCache(c.MaxBytes)
+		if c.Dir != "" {
+			disk, err := NewDiskCache(c.Dir)
+			if err != nil {
+				return nil, err
+			}
+			disk.MaxBytes = c.MaxBytes
+			disk.MaxAge = c.MaxAge
+			store = disk
+		}
+		getter = NewCache(getter, store, c.TTL)
+	}
+	return getter, nil
+}
+
+func (vc ValidatorConfig) build() (Validator, error) {
+	switch strings.ToLower(vc.Type) {
+	case "min_body_size":
+		return MinBodySize(vc.Min), nil
+	case "require_selector":
+		return RequireSelector(vc.Value), nil
+	case "require_pattern", "forbid_pattern":
+		pattern, err := regexp.Compile(vc.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%s validator: %w", vc.Type, err)
+		}
+		if strings.EqualFold(vc.Type, "forbid_pattern") {
+			return ForbidPattern(pattern), nil
+		}
+		return RequirePattern(pattern), nil
+	case "content_type":
+		return ContentType(vc.Values...), nil
+	case "status_range":
+		return StatusRange(vc.Min, vc.Max), nil
+	default:
+		return nil, fmt.Errorf("unknown validator type %q", vc.Type)
+	}
+}
+
+type healthConfig struct {
+	URL         string        `mapstructure:"url"`
+	MinStatus   int           `mapstructure:"min_status"`
+	MaxStatus   int           `mapstructure:"max_status"`
+	BodyPattern string        `mapstructure:"body_pattern"`
+	Timeout     time.Duration `mapstructure:"timeout"`
+}
+
+func (h healthConfig) healthCheck() (HealthCheck, error) {
+	pattern, err := compileBodyPattern(h.BodyPattern)
+	return HealthCheck{
+		URL:         h.URL,
+		MinStatus:   h.MinStatus,
+		MaxStatus:   h.MaxStatus,
+		BodyPattern: pattern,
+		Timeout:     h.Timeout,
+	}, err
+}
+
+func directFactory(client *http.Client, cfg GetterConfig) (SourceGetter, error) {
+	opts := struct {
+		Profile    string       `mapstructure:"profile"`
+		UserAgents []string     `mapstructure:"user_agents"`
+		CookieJar  string       `mapstructure:"cookie_jar"`
+		Health     healthConfig `mapstructure:"health"`
+	}{}
+	if err := cfg.DecodeOptions(&opts); err != nil {
+		return nil, err
+	}
+	d := NewDirect(client)
+	if opts.Profile != "" {
+		profile, err := LookupHeaderProfile(opts.Profile)
+		if err != nil {
+			return nil, err
+		}
+		d.Profile = &profile
+	}
+	d.UserAgents = opts.UserAgents
+	if opts.CookieJar != "" {
+		jar, err := NewHostCookieJarFromFile(opts.CookieJar)
+		if err != nil {
+			return nil, err
+		}
+		d.Jar = jar
+	}
+	var err error
+	if d.HealthCheck, err = opts.Health.healthCheck(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func zenRowsFactory(client *http.Client, cfg GetterConfig) (SourceGetter, error) {
+	opts := struct {
+		APIKey         string            `mapstructure:"api_key"`
+		HostURL        string            `mapstructure:"host_url"`
+		Cost           float64           `mapstructure:"cost"`
+		JSRender       bool              `mapstructure:"js_render"`
+		PremiumProxy   bool              `mapstructure:"premium_proxy"`
+		ProxyCountry   string            `mapstructure:"proxy_country"`
+		Wait           time.Duration     `mapstructure:"wait"`
+		WaitFor        string            `mapstructure:"wait_for"`
+		BlockResources []string          `mapstructure:"block_resources"`
+		CSSExtractor   map[string]string `mapstructure:"css_extractor"`
+		Autoparse      bool              `mapstructure:"autoparse"`
+		SessionID      int               `mapstructure:"session_id"`
+		OriginalStatus bool              `mapstructure:"original_status"`
+		Health         healthConfig      `mapstructure:"health"`
+	}{}
+	if err := cfg.DecodeOptions(&opts); err != nil {
+		return nil, err
+	}
+	z := NewZenRows(client, opts.APIKey, opts.JSRender)
+	if opts.HostURL != "" {
+		z.HostURL = opts.HostURL
+	}
+	if opts.Cost > 0 {
+		z.CostPerRequest = opts.Cost
+	}
+	z.Defaults = ZenRowsOptions{
+		PremiumProxy:   Bool(opts.PremiumProxy),
+		ProxyCountry:   opts.ProxyCountry,
+		Wait:           opts.Wait,
+		WaitFor:        opts.WaitFor,
+		BlockResources: opts.BlockResources,
+		CSSExtractor:   opts.CSSExtractor,
+		Autoparse:      Bool(opts.Autoparse),
+		SessionID:      opts.SessionID,
+		OriginalStatus: Bool(opts.OriginalStatus),
+	}
+	var err error
+	if z.HealthCheck, err = opts.Health.healthCheck(); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+func flareSolverFactory(client *http.Client, cfg GetterConfig) (SourceGetter, error) {
+	opts := struct {
+		HostURL    string        `mapstructure:"host_url"`
+		Sessions   bool          `mapstructure:"sessions"`
+		SessionTTL time.Duration `mapstructure:"session_ttl"`
+		Health     healthConfig  `mapstructure:"health"`
+	}{HostURL: "http://localhost:8191"}
+	if err := cfg.DecodeOptions(&opts); err != nil {
+		return nil, err
+	}
+	f := NewFlareSolver(client, opts.HostURL)
+	f.UseSessions = opts.Sessions
+	f.SessionTTL = opts.SessionTTL
+	var err error
+	if f.HealthCheck, err = opts.Health.healthCheck(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func browserFactory(client *http.Client, cfg GetterConfig) (SourceGetter, error) {
+	opts := struct {
+		DebuggerURL  string        `mapstructure:"debugger_url"`
+		ExecPath     string        `mapstructure:"exec_path"`
+		Flags        []string      `mapstructure:"flags"`
+		NetworkIdle  time.Duration `mapstructure:"network_idle"`
+		WaitSelector string        `mapstructure:"wait_selector"`
+		Screenshot   bool          `mapstructure:"screenshot"`
+		Timeout      time.Duration `mapstructure:"timeout"`
+	}{}
+	if err := cfg.DecodeOptions(&opts); err != nil {
+		return nil, err
+	}
+	b := NewBrowser(client, opts.DebuggerURL)
+	if opts.ExecPath != "" {
+		b.ExecPath = opts.ExecPath
+	}
+	if opts.Flags != nil {
+		b.Flags = opts.Flags
+	}
+	if opts.NetworkIdle > 0 {
+		b.NetworkIdle = opts.NetworkIdle
+	}
+	if opts.Timeout > 0 {
+		b.Timeout = opts.Timeout
+	}
+	b.WaitSelector = opts.WaitSelector
+	b.Screenshot = opts.Screenshot
+	return b, nil
+}
+
+func replayerFactory(client *http.Client, cfg GetterConfig) (SourceGetter, error) {
+	opts := struct {
+		Paths []string `mapstructure:"paths"`
+	}{}
+	if err := cfg.DecodeOptions(&opts); err != nil {
+		return nil, err
+	}
+	return NewReplayer(opts.Paths...)
+}
+
+func fallbackFactory(client *http.Client, cfg GetterConfig) (SourceGetter, error) {
+	if len(cfg.Getters) == 0 {
+		return nil, fmt.Errorf("fallback: %w", NoFallbackSourceErr)
+	}
+	getters := make([]SourceGetter, 0, len(cfg.Getters))
+	for i, gc := range cfg.Getters {
+		getter, err := gc.Build(client)
+		if err != nil {
+			return nil, fmt.Errorf("fallback getter %d: %w", i, err)
+		}
+		getters = append(getters, getter)
+	}
+	f := NewFallback(getters...)
+	fc := cfg.Fallback
+	if fc == nil {
+		return f, nil
+	}
+	if fc.PingTTL > 0 {
+		f.PingTTL = fc.PingTTL
+	}
+	if fc.FailureThreshold > 0 {
+		f.FailureThreshold = fc.FailureThreshold
+	}
+	if fc.Cooldown > 0 {
+		f.Cooldown = fc.Cooldown
+	}
+	var strategy Strategy
+	switch strings.ToLower(fc.Strategy) {
+	case "", "ordered":
+		strategy = OrderedStrategy{}
+	case "weighted":
+		strategy = NewWeightedStrategy(fc.Weights)
+	case "cost":
+		strategy = NewCostAwareStrategy(fc.Costs)
+	default:
+		return nil, fmt.Errorf("unknown fallback strategy %q", fc.Strategy)
+	}
+	if fc.Race {
+		strategy = &RaceStrategy{Strategy: strategy}
+	}
+	f.Strategy = strategy
+	if fc.RouterPath != "" || fc.RouteTTL > 0 {
+		ttl := fc.RouteTTL
+		if ttl <= 0 {
+			ttl = DefaultRouteTTL
+		}
+		router := NewRouter(ttl)
+		if fc.RouterPath != "" {
+			var err error
+			if router, err = NewRouterFromFile(fc.RouterPath, ttl); err != nil {
+				return nil, err
+			}
+		}
+		f.Router = router
+	}
+	return f, nil
+}
@@ -0,0 +1,129 @@
+package source_code
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFromConfigFile(t *testing.T) {
+	ctx := context.Background()
+	var userAgents []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents = append(userAgents, r.UserAgent())
+		_, _ = w.Write([]byte("<html><div id=content>page</div></html>"))
+	}))
+	defer server.Close()
+
+	var stubCfg GetterConfig
+	RegisterGetter("config-stub", func(client *http.Client, cfg GetterConfig) (SourceGetter, error) {
+		stubCfg = cfg
+		opts := struct {
+			Name string `mapstructure:"name"`
+		}{}
+		if err := cfg.DecodeOptions(&opts); err != nil {
+			return nil, err
+		}
+		return &stubGetter{name: opts.Name, err: errors.New("stub down")}, nil
+	})
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "wp.yaml")
+	config := `
+scraper:
+  type: fallback
+  fallback:
+    strategy: cost
+    costs: {stub: 0}
+    failure_threshold: 5
+  cache:
+    ttl: 1h
+  getters:
+    - type: config-stub
+      options: {name: stub}
+    - type: direct
+      options:
+        health: {url: "` + server.URL + `"}
+      user_agent: wp-test
+      retry: {max_retry: 2, initial_interval: 1ms, max_interval: 2ms, max_elapsed_time: 1s}
+      timeout: 5s
+      rate_limit: {rps: 100, burst: 10}
+      validators:
+        - {type: require_selector, value: "#content"}
+        - {type: status_range, min: 200, max: 299}
+`
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	getter, err := FromConfigFile(server.Client(), path, "scraper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, ok := getter.(*Cache)
+	if !ok {
+		t.Fatalf("expected cache outermost, got %T", getter)
+	}
+	f, ok := cache.getter.(*Fallback)
+	if !ok || len(f.Getters) != 2 || f.FailureThreshold != 5 {
+		t.Fatalf("unexpected fallback %#v", cache.getter)
+	}
+	if _, ok = f.Strategy.(*CostAwareStrategy); !ok {
+		t.Fatalf("unexpected strategy %T", f.Strategy)
+	}
+	if _, ok = f.Getters[1].(*RateLimited); !ok {
+		t.Fatalf("expected rate limited direct, got %T", f.Getters[1])
+	}
+	if stubCfg.Options["name"] != "stub" {
+		t.Fatalf("unexpected stub config %+v", stubCfg)
+	}
+
+	resp, err := getter.Get(ctx, server.URL+"/page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Getter != "direct" || !strings.Contains(string(resp.Body), "page") {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	if userAgents[len(userAgents)-1] != "wp-test" {
+		t.Fatalf("expected configured user agent, got %q", userAgents)
+	}
+	if resp, err = getter.Get(ctx, server.URL+"/page"); err != nil || !resp.Cached {
+		t.Fatalf("expected cached response, got %+v %v", resp, err)
+	}
+}
+
+func TestFromConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, config := range map[string]string{
+		"unknown type":      `{"getter": {"type": "telnet"}}`,
+		"unknown option":    `{"getter": {"type": "direct", "options": {"profil": "chrome"}}}`,
+		"unknown profile":   `{"getter": {"type": "direct", "options": {"profile": "lynx"}}}`,
+		"unknown validator": `{"getter": {"type": "direct", "validators": [{"type": "smell"}]}}`,
+		"empty fallback":    `{"getter": {"type": "fallback"}}`,
+		"invalid pattern":   `{"getter": {"type": "zenrows", "options": {"health": {"body_pattern": "("}}}}`,
+	} {
+		path := filepath.Join(dir, strings.ReplaceAll(name, " ", "-")+".json")
+		if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := FromConfigFile(nil, path, "getter"); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	path := filepath.Join(dir, "flare.json")
+	_ = os.WriteFile(path, []byte(`{"getter": {"type": "flaresolver", "options": {"sessions": true, "session_ttl": "10m"}}}`), 0o644)
+	getter, err := FromConfigFile(nil, path, "getter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := getter.(*FlareSolver); !f.UseSessions || f.SessionTTL != 10*time.Minute || f.HostURL != "http://localhost:8191" {
+		t.Fatalf("unexpected flaresolver %+v", f)
+	}
+}
@@ -0,0 +1,207 @@
+package source_code
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Seann-Moser/cutil/logc"
+	"go.uber.org/zap"
+	"golang.org/x/net/publicsuffix"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ http.CookieJar = &HostCookieJar{}
+
+// DefaultCookieSaveDelay is how long HostCookieJar batches changes before saving.
+var DefaultCookieSaveDelay = time.Second
+
+// HostCookieJar is an http.CookieJar that leaves matching to net/http/cookiejar
+// and saves every cookie it accepts to Path shortly after they change, so a
+// session survives restarts. Cookies are kept under the host or domain they were
+// set for. Session cookies without an expiry are saved too. Call Flush before
+// exiting to write any pending change.
+type HostCookieJar struct {
+	Path string
+	// SaveDelay is how long changes are batched before one save, defaulting
+	// to DefaultCookieSaveDelay.
+	SaveDelay time.Duration
+
+	mu        sync.Mutex
+	jar       *cookiejar.Jar
+	hosts     map[string][]*http.Cookie
+	saveTimer *time.Timer
+	saveErr   error
+}
+
+func NewHostCookieJar() *HostCookieJar {
+	return &HostCookieJar{jar: newCookieJar(), hosts: make(map[string][]*http.Cookie)}
+}
+
+func newCookieJar() *cookiejar.Jar {
+	// cookiejar.New only fails on options it does not use.
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	return jar
+}
+
+// NewHostCookieJarFromFile restores the cookies saved at path, which need not exist yet.
+func NewHostCookieJarFromFile(path string) (*HostCookieJar, error) {
+	j := NewHostCookieJar()
+	j.Path = path
+	if err := j.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *HostCookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.jar == nil {
+		j.jar = newCookieJar()
+	}
+	j.jar.SetCookies(u, cookies)
+	if j.hosts == nil {
+		j.hosts = make(map[string][]*http.Cookie)
+	}
+	now := time.Now()
+	host := strings.ToLower(u.Hostname())
+	for _, c := range cookies {
+		c := *c
+		owner := host
+		if c.Domain != "" {
+			c.Domain = strings.TrimPrefix(strings.ToLower(c.Domain), ".")
+			if host != c.Domain && !strings.HasSuffix(host, "."+c.Domain) {
+				continue
+			}
+			owner = c.Domain
+		}
+		if c.Path == "" || c.Path[0] != '/' {
+			c.Path = defaultCookiePath(u.Path)
+		}
+		if c.MaxAge > 0 {
+			c.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+			c.MaxAge = 0
+		}
+		expired := c.MaxAge < 0 || (!c.Expires.IsZero() && c.Expires.Before(now))
+		kept := j.hosts[owner][:0]
+		for _, existing := range j.hosts[owner] {
+			if existing.Name != c.Name || existing.Path != c.Path || (existing.Domain == "") != (c.Domain == "") {
+				kept = append(kept, existing)
+			}
+		}
+		if !expired {
+			kept = append(kept, &c)
+		}
+		j.hosts[owner] = kept
+	}
+	if j.Path != "" && j.saveTimer == nil {
+		delay := j.SaveDelay
+		if delay <= 0 {
+			delay = DefaultCookieSaveDelay
+		}
+		j.saveTimer = time.AfterFunc(delay, j.save)
+	}
+}
+
+// defaultCookiePath is the path a cookie without one applies to, as in RFC 6265 section 5.1.4.
+func defaultCookiePath(path string) string {
+	i := strings.LastIndex(path, "/")
+	if i <= 0 || path[0] != '/' {
+		return "/"
+	}
+	return path[:i]
+}
+
+// save writes the batched changes, logging a failure and keeping it for Err.
+func (j *HostCookieJar) save() {
+	j.mu.Lock()
+	j.saveTimer = nil
+	j.mu.Unlock()
+	err := j.Save()
+	if err != nil {
+		logc.Warn(context.Background(), "failed saving cookies", zap.String("path", j.Path), zap.Error(err))
+	}
+	j.mu.Lock()
+	j.saveErr = err
+	j.mu.Unlock()
+}
+
+// Flush saves any change still waiting for SaveDelay and returns the result.
+func (j *HostCookieJar) Flush() error {
+	j.mu.Lock()
+	pending := j.saveTimer != nil && j.saveTimer.Stop()
+	j.saveTimer = nil
+	j.mu.Unlock()
+	if !pending {
+		return j.Err()
+	}
+	err := j.Save()
+	j.mu.Lock()
+	j.saveErr = err
+	j.mu.Unlock()
+	return err
+}
+
+// Err returns the error from the last save, nil when it succeeded.
+func (j *HostCookieJar) Err() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.saveErr
+}
+
+func (j *HostCookieJar) Cookies(u *url.URL) []*http.Cookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.jar == nil {
+		return nil
+	}
+	return j.jar.Cookies(u)
+}
+
+func (j *HostCookieJar) Save() error {
+	j.mu.Lock()
+	data, err := json.MarshalIndent(j.hosts, "", "  ")
+	j.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(j.Path, data)
+}
+
+// Load replaces the cookies with the ones saved at Path.
+func (j *HostCookieJar) Load() error {
+	data, err := os.ReadFile(j.Path)
+	if err != nil {
+		return err
+	}
+	hosts := map[string][]*http.Cookie{}
+	if err = json.Unmarshal(data, &hosts); err != nil {
+		return err
+	}
+	// Replaying the saved cookies for their host gives the jar back the same
+	// host-only and domain cookies, minus the ones that expired meanwhile.
+	jar := newCookieJar()
+	now := time.Now()
+	for host, cookies := range hosts {
+		kept := cookies[:0]
+		for _, c := range cookies {
+			if !c.Expires.IsZero() && c.Expires.Before(now) {
+				continue
+			}
+			jar.SetCookies(&url.URL{Scheme: "https", Host: host, Path: c.Path}, []*http.Cookie{c})
+			kept = append(kept, c)
+		}
+		hosts[host] = kept
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.jar = jar
+	j.hosts = hosts
+	return nil
+}
@@ -3,16 +3,42 @@ package source_code
 import (
 	"context"
 	"fmt"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
 	"io"
 	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var _ SourceGetter = &Direct{}
 
 type Direct struct {
 	client  *http.Client
-	enabled bool
-	err     error
+	enabled atomic.Bool
+	// Clearances, when set, adds cookies and the user agent a solver such as
+	// FlareSolver obtained for the host so Direct can skip the challenge.
+	Clearances *ClearanceStore
+	// Profile, when set, adds a browser's navigation headers to every request.
+	Profile *HeaderProfile
+	// UserAgents overrides the profile's user agents. Each host is given the next
+	// one in turn and keeps it, so its cookies stay paired with one browser.
+	UserAgents []string
+	// Jar replaces the client's cookie jar, e.g. with a HostCookieJar saved to disk.
+	Jar         http.CookieJar
+	HealthCheck HealthCheck
+
+	uaMu    sync.Mutex
+	uaNext  int
+	uaHosts map[string]string
+
+	errMu sync.Mutex
+	err   error
+
+	clientsMu sync.Mutex
+	clients   map[string]*http.Client
 }
 
 func NewDirect(client *http.Client) *Direct {
@@ -21,65 +47,221 @@ func NewDirect(client *http.Client) *Direct {
 	}
 }
 
-func (d *Direct) Get(ctx context.Context, endpoint string, options ...SourceOptions) ([]byte, int, error) {
-	if !d.Enabled() {
-		return nil, http.StatusNotImplemented, fmt.Errorf("%s is not enabled", d.Name())
+func DirectFlags() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("direct", pflag.ExitOnError)
+	fs.String("direct-profile", "", "Browser header profile: chrome, firefox or safari")
+	fs.StringSlice("direct-user-agents", nil, "User agents to rotate through, one per host")
+	fs.String("direct-cookie-jar", "", "File to keep cookies in between runs")
+	fs.AddFlagSet(HealthCheckFlags("direct"))
+	return fs
+}
+
+func NewDirectFromFlags(client *http.Client) (*Direct, error) {
+	d := NewDirect(client)
+	var err error
+	if d.HealthCheck, err = NewHealthCheckWithFlags("direct"); err != nil {
+		return nil, err
 	}
-	r, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
-	if err != nil {
-		return nil, http.StatusInternalServerError, err
+	if name := viper.GetString("direct-profile"); name != "" {
+		profile, err := LookupHeaderProfile(name)
+		if err != nil {
+			return nil, err
+		}
+		d.Profile = &profile
 	}
-	resp, err := d.client.Do(r)
-	if err != nil {
-		return nil, 0, err
+	d.UserAgents = viper.GetStringSlice("direct-user-agents")
+	if path := viper.GetString("direct-cookie-jar"); path != "" {
+		jar, err := NewHostCookieJarFromFile(path)
+		if err != nil {
+			return nil, err
+		}
+		d.Jar = jar
 	}
-	body, err := io.ReadAll(resp.Body)
+	return d, nil
+}
+
+func (d *Direct) Get(ctx context.Context, endpoint string, options ...SourceOptions) (*SourceResponse, error) {
+	o := mergeSourceOptions(options...)
+	return o.run(ctx, func(ctx context.Context) (*SourceResponse, error) {
+		return d.get(ctx, endpoint, o)
+	})
+}
+
+func (d *Direct) get(ctx context.Context, endpoint string, o SourceOptions) (*SourceResponse, error) {
+	sr := newSourceResponse(d.Name(), time.Now())
+	resp, err := d.do(ctx, sr, endpoint, o)
 	if err != nil {
-		return nil, resp.StatusCode, err
+		return sr.done(), err
 	}
-	if HasChallange(body) {
-		return body, resp.StatusCode, HasChallengeErr
+	sr.fromHTTP(resp)
+	if err = sr.readBody(resp, o.MaxBodyBytes); err != nil {
+		return sr.done(), err
 	}
-	return body, resp.StatusCode, nil
+	if err = detectChallengeErr(sr.StatusCode, sr.Headers, sr.Body); err != nil {
+		return sr.done(), err
+	}
+	return sr.done(), nil
 }
 
-func (d *Direct) Ping(ctx context.Context) bool {
-	r, err := http.NewRequestWithContext(ctx, http.MethodGet, PingURL, nil)
+// GetStream sends the request like Get but returns the body unread; the caller must close it.
+func (d *Direct) GetStream(ctx context.Context, endpoint string, options ...SourceOptions) (io.ReadCloser, *Meta, error) {
+	o := mergeSourceOptions(options...)
+	ctx, cancel := o.streamContext(ctx)
+	sr := newSourceResponse(d.Name(), time.Now())
+	resp, err := d.do(ctx, sr, endpoint, o)
 	if err != nil {
-		d.enabled = false
-		d.err = err
-		return false
+		cancel()
+		return nil, sr.meta(), err
 	}
-	resp, err := d.client.Do(r)
+	sr.fromHTTP(resp)
+	return streamBody(resp, o.MaxBodyBytes, cancel), sr.meta(), nil
+}
+
+// do builds and sends the request, setting sr.StatusCode when it never gets a response.
+func (d *Direct) do(ctx context.Context, sr *SourceResponse, endpoint string, o SourceOptions) (*http.Response, error) {
+	if !d.Enabled() {
+		sr.StatusCode = http.StatusNotImplemented
+		return nil, fmt.Errorf("%s is %w", d.Name(), NotEnabledErr)
+	}
+	r, err := http.NewRequestWithContext(ctx, o.method(), endpoint, o.body())
 	if err != nil {
-		d.enabled = false
-		d.err = err
-		return d.enabled
+		sr.StatusCode = http.StatusInternalServerError
+		return nil, err
+	}
+	o.apply(r)
+	if d.Clearances != nil {
+		d.Clearances.apply(r)
 	}
-	d.enabled = resp.StatusCode >= http.StatusOK || resp.StatusCode < 300
-	if !d.enabled {
-		return false
+	if d.Profile != nil {
+		d.Profile.apply(r, d.userAgent(r.URL.Hostname()))
+	} else if ua := d.userAgent(r.URL.Hostname()); ua != "" && r.Header.Get("User-Agent") == "" {
+		r.Header.Set("User-Agent", ua)
 	}
-	body, err := io.ReadAll(resp.Body)
+	client, err := d.clientFor(o.Proxy)
 	if err != nil {
-		return false
+		sr.StatusCode = http.StatusInternalServerError
+		return nil, err
 	}
-	if HasChallange(body) {
-		d.enabled = false
-		d.err = HasChallengeErr
-		return false
+	return client.Do(r)
+}
+
+// userAgent returns the user agent host was given, picking the next one from
+// UserAgents or the profile on its first request.
+func (d *Direct) userAgent(host string) string {
+	agents := d.UserAgents
+	if len(agents) == 0 && d.Profile != nil {
+		agents = d.Profile.UserAgents
+	}
+	if len(agents) == 0 {
+		return ""
+	}
+	d.uaMu.Lock()
+	defer d.uaMu.Unlock()
+	if ua, found := d.uaHosts[host]; found {
+		return ua
+	}
+	ua := agents[d.uaNext%len(agents)]
+	d.uaNext++
+	if d.uaHosts == nil {
+		d.uaHosts = make(map[string]string)
+	}
+	d.uaHosts[host] = ua
+	return ua
+}
+
+// clientFor returns the client to send through proxy: d.client itself, or a copy
+// using Jar and a transport cloned with the proxy and the profile's header order
+// set. Clients are kept per proxy so their connections are reused.
+func (d *Direct) clientFor(proxy string) (*http.Client, error) {
+	ordered := d.Profile != nil && len(d.Profile.Order) > 0
+	if proxy == "" && d.Jar == nil && !ordered {
+		return d.client, nil
+	}
+	d.clientsMu.Lock()
+	defer d.clientsMu.Unlock()
+	if client, found := d.clients[proxy]; found {
+		return client, nil
 	}
-	return d.enabled
+	client := *d.client
+	if d.Jar != nil {
+		client.Jar = d.Jar
+	}
+	if proxy != "" || ordered {
+		var transport *http.Transport
+		switch t := d.client.Transport.(type) {
+		case nil:
+			transport = http.DefaultTransport.(*http.Transport).Clone()
+		case *http.Transport:
+			transport = t.Clone()
+		default:
+			if proxy != "" {
+				return nil, fmt.Errorf("%s cannot set a proxy on a %T transport", d.Name(), t)
+			}
+		}
+		// Other transports, such as a Replayer, keep their own header order.
+		if transport != nil {
+			if proxy != "" {
+				proxyURL, err := url.Parse(proxy)
+				if err != nil {
+					return nil, fmt.Errorf("invalid proxy: %w", err)
+				}
+				transport.Proxy = http.ProxyURL(proxyURL)
+			}
+			if ordered {
+				orderHeaders(transport, d.Profile.Order)
+			}
+			client.Transport = transport
+		}
+	}
+	if d.clients == nil {
+		d.clients = make(map[string]*http.Client)
+	}
+	d.clients[proxy] = &client
+	return &client, nil
+}
+
+// Ping fetches HealthCheck.URL, or PingURL, with the same profile as Get.
+func (d *Direct) Ping(ctx context.Context) HealthStatus {
+	status := d.HealthCheck.run(ctx, d.Name(), d.client, func(ctx context.Context) (*http.Request, error) {
+		endpoint := d.HealthCheck.URL
+		if endpoint == "" {
+			endpoint = PingURL
+		}
+		r, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		if d.Profile != nil {
+			d.Profile.apply(r, d.userAgent(r.URL.Hostname()))
+		}
+		return r, nil
+	}, nil)
+	d.enabled.Store(status.Healthy)
+	d.setErr(status.Err)
+	return status
 }
 
 func (d *Direct) Enabled() bool {
-	return d.enabled
+	return d.enabled.Load()
 }
 
 func (d *Direct) Err() error {
+	d.errMu.Lock()
+	defer d.errMu.Unlock()
 	return d.err
 }
 
+func (d *Direct) setErr(err error) {
+	d.errMu.Lock()
+	defer d.errMu.Unlock()
+	d.err = err
+}
+
+// HandlesChallenge is always false, retrying a plain request does not get past a challenge.
+func (d *Direct) HandlesChallenge(info ChallengeInfo) bool {
+	return false
+}
+
 func (d *Direct) Name() string {
 	return "direct"
 }
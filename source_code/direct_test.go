@@ -1,23 +1,263 @@
 package source_code
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
+	"errors"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestDirect(t *testing.T) {
 	ctx := context.Background()
-	d := NewDirect(http.DefaultClient)
+	// direct.har is synthetic, written by hand rather than recorded.
+	replayer, err := NewReplayer("testdata/direct.har")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDirect(&http.Client{Transport: replayer})
 	d.Ping(ctx)
-	_, _, err := d.Get(ctx, "https://idope.se/browse.html")
-	if err == nil || err.Error() != HasChallengeErr.Error() {
+	_, err = d.Get(ctx, "https://idope.se/browse.html")
+	if !errors.Is(err, HasChallengeErr) {
 		t.Fatalf("expected HasChallengeErr, got %v", err)
 	}
 
-	_, _, err = d.Get(ctx, "https://github.com/Seann-Moser/wp")
+	_, err = d.Get(ctx, "https://github.com/Seann-Moser/wp")
 	if err != nil {
 		t.Fatalf("expected HasChallengeErr, got %v", err)
 	}
 
 }
+
+func TestDirectResponse(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/final", http.StatusFound)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte("<html><title>ok</title></html>"))
+	}))
+	defer server.Close()
+
+	d := NewDirect(server.Client())
+	d.enabled.Store(true)
+	resp, err := d.Get(ctx, server.URL+"/redirect")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+	if resp.FinalURL != server.URL+"/final" {
+		t.Fatalf("expected final url %s/final, got %s", server.URL, resp.FinalURL)
+	}
+	if resp.MediaType() != "text/html" {
+		t.Fatalf("expected text/html, got %s", resp.MediaType())
+	}
+	if len(resp.Cookies) != 1 || resp.Cookies[0].Name != "session" {
+		t.Fatalf("expected session cookie, got %v", resp.Cookies)
+	}
+	if resp.Getter != d.Name() {
+		t.Fatalf("expected getter %s, got %s", d.Name(), resp.Getter)
+	}
+}
+
+func TestDirectProfileAndCookieJar(t *testing.T) {
+	ctx := context.Background()
+	var requests []*http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/", MaxAge: 3600})
+		_, _ = w.Write([]byte("<html></html>"))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	jar, err := NewHostCookieJarFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile, err := LookupHeaderProfile("Firefox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDirect(server.Client())
+	d.enabled.Store(true)
+	d.Profile = &profile
+	d.UserAgents = []string{"ua-one", "ua-two"}
+	d.Jar = jar
+
+	for i := 0; i < 2; i++ {
+		if _, err = d.Get(ctx, server.URL+"/page", SourceOptions{Headers: http.Header{"Accept-Language": {"de"}}}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	first, second := requests[0], requests[1]
+	if first.UserAgent() != "ua-one" || second.UserAgent() != "ua-one" {
+		t.Fatalf("expected the host to keep its user agent, got %q %q", first.UserAgent(), second.UserAgent())
+	}
+	if first.Header.Get("Sec-Fetch-Mode") != "navigate" || first.Header.Get("Accept-Language") != "de" {
+		t.Fatalf("unexpected headers %v", first.Header)
+	}
+	if _, err = first.Cookie("session"); err == nil {
+		t.Fatal("first request should not carry a cookie")
+	}
+	if c, err := second.Cookie("session"); err != nil || c.Value != "abc" {
+		t.Fatalf("expected jar cookie on second request: %v", err)
+	}
+
+	if _, err = os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the save to wait for SaveDelay, got %v", err)
+	}
+	if err = jar.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := NewHostCookieJarFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(server.URL + "/other")
+	if cookies := restored.Cookies(u); len(cookies) != 1 || cookies[0].Value != "abc" {
+		t.Fatalf("expected cookie persisted, got %v", cookies)
+	}
+	broken := NewHostCookieJar()
+	broken.Path = filepath.Join(t.TempDir(), "missing", "cookies.json")
+	broken.SaveDelay = time.Millisecond
+	broken.SetCookies(u, []*http.Cookie{{Name: "session", Value: "abc"}})
+	time.Sleep(50 * time.Millisecond)
+	if broken.Err() == nil || broken.Flush() == nil {
+		t.Fatal("expected the failed save to be reported")
+	}
+	if _, err = LookupHeaderProfile("lynx"); err == nil {
+		t.Fatal("expected unknown profile error")
+	}
+}
+
+// rawHeaderServer answers every request on l with a small page and sends the
+// header names of each request, in the order they arrived, on the returned channel.
+func rawHeaderServer(t *testing.T, l net.Listener) <-chan []string {
+	requests := make(chan []string, 10)
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					var names []string
+					for {
+						line, err := r.ReadString('\n')
+						if err != nil {
+							return
+						}
+						line = strings.TrimRight(line, "\r\n")
+						if line == "" {
+							break
+						}
+						if name, _, found := strings.Cut(line, ":"); found {
+							names = append(names, name)
+						}
+					}
+					requests <- names
+					_, _ = conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 13\r\n\r\n<html></html>"))
+				}
+			}()
+		}
+	}()
+	return requests
+}
+
+func TestDirectHeaderOrder(t *testing.T) {
+	ctx := context.Background()
+	tlsServer := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tlsServer.Close()
+	plain, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secure, err := tls.Listen("tcp", "127.0.0.1:0", tlsServer.TLS.Clone())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		url      string
+		requests <-chan []string
+	}{
+		{"http://" + plain.Addr().String(), rawHeaderServer(t, plain)},
+		{"https://" + secure.Addr().String(), rawHeaderServer(t, secure)},
+	} {
+		d := NewDirect(tlsServer.Client())
+		d.enabled.Store(true)
+		d.Profile = &ChromeProfile
+		for i := 0; i < 2; i++ {
+			if _, err = d.Get(ctx, tt.url+"/page", SourceOptions{Headers: http.Header{"X-Extra": {"1"}}}); err != nil {
+				t.Fatal(err)
+			}
+			names := <-tt.requests
+			want := []string{"Host", "Sec-Ch-Ua", "Sec-Ch-Ua-Mobile", "Upgrade-Insecure-Requests", "User-Agent", "Accept",
+				"Sec-Fetch-Site", "Sec-Fetch-Mode", "Sec-Fetch-User", "Sec-Fetch-Dest", "Accept-Encoding", "Accept-Language",
+				"Priority", "X-Extra"}
+			if strings.Join(names, ",") != strings.Join(want, ",") {
+				t.Fatalf("%s request %d: unexpected header order %v", tt.url, i, names)
+			}
+		}
+	}
+}
+
+func TestHostCookieJarDomainCookies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	jar, err := NewHostCookieJarFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	login, _ := url.Parse("https://login.example.com/account/signin")
+	jar.SetCookies(login, []*http.Cookie{
+		{Name: "shared", Value: "parent", Domain: ".example.com", Path: "/"},
+		{Name: "local", Value: "login"},
+		{Name: "public", Value: "x", Domain: "com"},
+	})
+	www, _ := url.Parse("https://www.example.com/")
+	account, _ := url.Parse("https://login.example.com/account/settings")
+	for u, want := range map[*url.URL]string{www: "shared=parent", account: "local=login; shared=parent"} {
+		var got []string
+		for _, c := range jar.Cookies(u) {
+			got = append(got, c.String())
+		}
+		sort.Strings(got)
+		if strings.Join(got, "; ") != want {
+			t.Fatalf("%s: expected %q, got %v", u, want, got)
+		}
+	}
+
+	if err = jar.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := NewHostCookieJarFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cookies := restored.Cookies(www); len(cookies) != 1 || cookies[0].Value != "parent" {
+		t.Fatalf("expected the domain cookie restored, got %v", cookies)
+	}
+	restored.SetCookies(www, []*http.Cookie{{Name: "shared", Domain: "example.com", Path: "/", MaxAge: -1}})
+	if cookies := restored.Cookies(account); len(cookies) != 1 || cookies[0].Name != "local" {
+		t.Fatalf("expected the domain cookie deleted from a sibling host, got %v", cookies)
+	}
+}
@@ -2,64 +2,279 @@ package source_code
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"sync"
+	"time"
 )
 
 var _ SourceGetter = &Fallback{}
 
+var (
+	DefaultPingTTL          = 5 * time.Minute
+	DefaultFailureThreshold = 3
+	DefaultCooldown         = time.Minute
+)
+
 type Fallback struct {
 	Getters []SourceGetter
-	status  map[string]bool
+	// PingTTL is how long a getter's Ping result is trusted before Get pings it again.
+	PingTTL time.Duration
+	// FailureThreshold consecutive failures open a getter's circuit for Cooldown.
+	FailureThreshold int
+	Cooldown         time.Duration
+	// Strategy picks the order getters are tried in, defaulting to OrderedStrategy.
+	Strategy Strategy
+	// Router, when set, moves the getter that last served a host to the front and
+	// learns from every success.
+	Router *Router
+	// Metrics, when set, counts every time Get moves past a failed or skipped getter.
+	Metrics *Metrics
+
+	once   sync.Once
+	health *healthRegistry
 }
 
 func NewFallback(getters ...SourceGetter) *Fallback {
 	return &Fallback{
-		Getters: getters,
-		status:  make(map[string]bool),
+		Getters:          getters,
+		PingTTL:          DefaultPingTTL,
+		FailureThreshold: DefaultFailureThreshold,
+		Cooldown:         DefaultCooldown,
+		health:           newHealthRegistry(),
 	}
 }
 
-func (f *Fallback) Get(ctx context.Context, endpoint string, options ...SourceOptions) ([]byte, int, error) {
-	for _, getter := range f.Getters {
-		enabled, found := f.status[endpoint]
-		if !found {
-			f.status[getter.Name()] = getter.Ping(ctx)
-			enabled = f.status[getter.Name()]
+func (f *Fallback) registry() *healthRegistry {
+	f.once.Do(func() {
+		if f.health == nil {
+			f.health = newHealthRegistry()
+		}
+	})
+	return f.health
+}
+
+func (f *Fallback) Get(ctx context.Context, endpoint string, options ...SourceOptions) (*SourceResponse, error) {
+	if o := mergeSourceOptions(options...); o.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.MaxDuration)
+		defer cancel()
+	}
+	strategy := f.Strategy
+	if strategy == nil {
+		strategy = OrderedStrategy{}
+	}
+	getters := strategy.Order(f.Getters)
+	if f.Router != nil {
+		getters = f.Router.Order(endpoint, getters)
+	}
+	if _, ok := strategy.(*RaceStrategy); ok {
+		return f.race(ctx, getters, endpoint, options...)
+	}
+
+	fallbackErr := &FallbackError{}
+	for i := 0; i < len(getters); i++ {
+		resp, attempt, err := f.attempt(ctx, getters[i], endpoint, options...)
+		if err == nil {
+			f.learn(ctx, endpoint, attempt.Getter)
+			return resp, nil
+		}
+		fallbackErr.Attempts = append(fallbackErr.Attempts, attempt)
+		if ctx.Err() != nil {
+			return resp, fallbackErr
+		}
+		f.Metrics.escalation(attempt)
+		var challengeErr *ChallengeError
+		if errors.As(err, &challengeErr) {
+			getters = append(getters[:i+1:i+1], preferChallengeHandlers(getters[i+1:], challengeErr.Info)...)
+		}
+	}
+	return nil, fallbackErr
+}
+
+// preferChallengeHandlers moves getters that handle the challenge to the front and
+// getters that declare they cannot handle it to the back, keeping the order otherwise.
+func preferChallengeHandlers(getters []SourceGetter, info ChallengeInfo) []SourceGetter {
+	var handles, unknown, cannot []SourceGetter
+	for _, getter := range getters {
+		handler, ok := asGetter[ChallengeHandler](getter)
+		switch {
+		case !ok:
+			unknown = append(unknown, getter)
+		case handler.HandlesChallenge(info):
+			handles = append(handles, getter)
+		default:
+			cannot = append(cannot, getter)
+		}
+	}
+	return append(append(handles, unknown...), cannot...)
+}
+
+func (f *Fallback) race(ctx context.Context, getters []SourceGetter, endpoint string, options ...SourceOptions) (*SourceResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		resp    *SourceResponse
+		attempt FallbackAttempt
+		err     error
+	}
+	results := make(chan result, len(getters))
+	for _, getter := range getters {
+		go func(getter SourceGetter) {
+			resp, attempt, err := f.attempt(ctx, getter, endpoint, options...)
+			results <- result{resp: resp, attempt: attempt, err: err}
+		}(getter)
+	}
+
+	fallbackErr := &FallbackError{}
+	for range getters {
+		r := <-results
+		if r.err == nil {
+			f.learn(ctx, endpoint, r.attempt.Getter)
+			return r.resp, nil
 		}
-		if !enabled {
-			continue
+		fallbackErr.Attempts = append(fallbackErr.Attempts, r.attempt)
+		f.Metrics.escalation(r.attempt)
+	}
+	return nil, fallbackErr
+}
+
+// attempt pings, checks the circuit of and calls a single getter, recording the
+// outcome in the health registry. The returned error is nil only on success.
+func (f *Fallback) attempt(ctx context.Context, getter SourceGetter, endpoint string, options ...SourceOptions) (*SourceResponse, FallbackAttempt, error) {
+	health := f.registry()
+	name := getter.Name()
+	enabled := health.ping(name, f.PingTTL, time.Now(), func() HealthStatus {
+		return getter.Ping(ctx)
+	})
+	if !enabled {
+		attempt := FallbackAttempt{
+			Getter:  name,
+			Skipped: true,
+			Err:     fmt.Errorf("%s is %w", name, NotEnabledErr),
 		}
-		data, status, err := getter.Get(ctx, endpoint, options...)
-		if err != nil {
-			continue
+		return nil, attempt, attempt.Err
+	}
+	if !health.allow(name, f.Cooldown, time.Now()) {
+		attempt := FallbackAttempt{
+			Getter:  name,
+			Skipped: true,
+			Err:     fmt.Errorf("%s %w", name, CircuitOpenErr),
 		}
-		return data, status, err
+		return nil, attempt, attempt.Err
+	}
+	start := time.Now()
+	resp, err := getter.Get(ctx, endpoint, options...)
+	if err == nil {
+		health.success(name)
+		return resp, FallbackAttempt{Getter: name, Duration: time.Since(start), Response: resp}, nil
+	}
+	attempt := FallbackAttempt{
+		Getter:   name,
+		Duration: time.Since(start),
+		Err:      err,
+		Response: resp,
+	}
+	if resp != nil {
+		attempt.StatusCode = resp.StatusCode
+	}
+	if errors.Is(err, ErrBudgetExceeded) {
+		// An exhausted budget says nothing about the getter's health.
+		attempt.Skipped = true
+		health.release(name)
+	} else if errors.Is(err, HasChallengeErr) || errors.Is(err, ValidationErr) {
+		// A challenge or failed validator says the page was bad, not the getter.
+		health.release(name)
+	} else if ctx.Err() != nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		health.release(name)
+	} else {
+		health.failure(name, f.FailureThreshold, time.Now())
+	}
+	return resp, attempt, err
+}
+
+// learn routes endpoint to the getter that served it. Only the result Get returns
+// is learned, so a race loser finishing late cannot overwrite the winner's route.
+func (f *Fallback) learn(ctx context.Context, endpoint, getter string) {
+	if f.Router != nil {
+		f.Router.Learn(ctx, endpoint, getter)
 	}
-	return nil, 0, fmt.Errorf("no fallback source")
 }
 
-func (f *Fallback) Ping(ctx context.Context) bool {
+// Ping pings every getter concurrently, refreshing their health. The Fallback is
+// healthy when any getter is; Latency is the slowest ping.
+func (f *Fallback) Ping(ctx context.Context) HealthStatus {
+	status := HealthStatus{Getter: f.Name(), CheckedAt: time.Now()}
 	if len(f.Getters) == 0 {
-		return false
+		status.Err = NoFallbackSourceErr
+		return status
 	}
-	for _, getter := range f.Getters {
-		f.status[getter.Name()] = getter.Ping(ctx)
+	health := f.registry()
+	statuses := make([]HealthStatus, len(f.Getters))
+	var wg sync.WaitGroup
+	for i, getter := range f.Getters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i] = getter.Ping(ctx)
+			health.setPing(getter.Name(), statuses[i])
+		}()
+	}
+	wg.Wait()
+	var errs []error
+	for _, s := range statuses {
+		status.Healthy = status.Healthy || s.Healthy
+		if s.Err != nil {
+			errs = append(errs, s.Err)
+		}
+	}
+	status.Latency = time.Since(status.CheckedAt)
+	if !status.Healthy {
+		status.Err = errors.Join(errs...)
 	}
-	return f.Enabled()
+	return status
+}
+
+// StartHealthChecks pings every getter now and then every interval until ctx is
+// done, so Get finds fresh results instead of pinging inline. PingTTL should be
+// longer than interval for that to hold.
+func (f *Fallback) StartHealthChecks(ctx context.Context, interval time.Duration) {
+	f.Ping(ctx)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				f.Ping(ctx)
+			}
+		}
+	}()
+}
+
+// Health returns a snapshot of every getter the Fallback has tracked, sorted by name.
+func (f *Fallback) Health() []GetterHealth {
+	health := f.registry().snapshot()
+	sort.Slice(health, func(i, j int) bool {
+		return health[i].Name < health[j].Name
+	})
+	return health
 }
 
 func (f *Fallback) Name() string {
 	return "fallback"
 }
 
+// Enabled reports whether any getter is enabled, matching Ping.
 func (f *Fallback) Enabled() bool {
-	if len(f.Getters) == 0 {
-		return false
-	}
 	for _, getter := range f.Getters {
-		if !getter.Enabled() {
-			return false
+		if getter.Enabled() {
+			return true
 		}
 	}
-	return true
+	return false
 }
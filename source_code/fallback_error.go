@@ -0,0 +1,78 @@
+package source_code
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var NoFallbackSourceErr = errors.New("no fallback source")
+var CircuitOpenErr = errors.New("circuit open")
+
+// FallbackAttempt records what happened to one getter during Fallback.Get.
+// Skipped attempts never reached the getter's Get because it was disabled or its circuit was open.
+type FallbackAttempt struct {
+	Getter     string
+	StatusCode int
+	Duration   time.Duration
+	Skipped    bool
+	Err        error
+	Response   *SourceResponse
+}
+
+func (a FallbackAttempt) String() string {
+	if a.Skipped {
+		return fmt.Sprintf("%s: skipped: %v", a.Getter, a.Err)
+	}
+	return fmt.Sprintf("%s (status %d, %s): %v", a.Getter, a.StatusCode, a.Duration, a.Err)
+}
+
+// FallbackError is returned by Fallback.Get when no getter produced a response.
+// errors.Is and errors.As see through it to every attempt's error.
+type FallbackError struct {
+	Attempts []FallbackAttempt
+}
+
+func (f *FallbackError) Error() string {
+	if len(f.Attempts) == 0 {
+		return NoFallbackSourceErr.Error()
+	}
+	attempts := make([]string, 0, len(f.Attempts))
+	for _, a := range f.Attempts {
+		attempts = append(attempts, a.String())
+	}
+	return fmt.Sprintf("%s: %s", NoFallbackSourceErr, strings.Join(attempts, "; "))
+}
+
+func (f *FallbackError) Unwrap() []error {
+	errs := []error{NoFallbackSourceErr}
+	for _, a := range f.Attempts {
+		if a.Err != nil {
+			errs = append(errs, a.Err)
+		}
+	}
+	return errs
+}
+
+// Tried returns the attempts that actually called the getter.
+func (f *FallbackError) Tried() []FallbackAttempt {
+	var tried []FallbackAttempt
+	for _, a := range f.Attempts {
+		if !a.Skipped {
+			tried = append(tried, a)
+		}
+	}
+	return tried
+}
+
+// Skipped returns the attempts for getters that were not called.
+func (f *FallbackError) Skipped() []FallbackAttempt {
+	var skipped []FallbackAttempt
+	for _, a := range f.Attempts {
+		if a.Skipped {
+			skipped = append(skipped, a)
+		}
+	}
+	return skipped
+}
@@ -0,0 +1,165 @@
+package source_code
+
+import (
+	"sync"
+	"time"
+)
+
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (c CircuitState) String() string {
+	switch c {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// GetterHealth is the Fallback's view of a single getter, keyed by its Name.
+// Status is the latest Ping and LastError the most recent failed one.
+type GetterHealth struct {
+	Name                string
+	Enabled             bool
+	LastPing            time.Time
+	Status              HealthStatus
+	LastError           error
+	ConsecutiveFailures int
+	State               CircuitState
+	OpenedAt            time.Time
+}
+
+type healthEntry struct {
+	GetterHealth
+	pingMu  sync.Mutex
+	probing bool
+}
+
+type healthRegistry struct {
+	mu      sync.Mutex
+	entries map[string]*healthEntry
+}
+
+func newHealthRegistry() *healthRegistry {
+	return &healthRegistry{
+		entries: make(map[string]*healthEntry),
+	}
+}
+
+func (h *healthRegistry) entry(name string) *healthEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e, found := h.entries[name]
+	if !found {
+		e = &healthEntry{GetterHealth: GetterHealth{Name: name}}
+		h.entries[name] = e
+	}
+	return e
+}
+
+// ping pings the getter when its last result is older than ttl. Concurrent callers
+// for the same getter wait for the in-flight ping instead of issuing their own.
+func (h *healthRegistry) ping(name string, ttl time.Duration, now time.Time, ping func() HealthStatus) bool {
+	e := h.entry(name)
+	e.pingMu.Lock()
+	defer e.pingMu.Unlock()
+
+	h.mu.Lock()
+	fresh := !e.LastPing.IsZero() && (ttl <= 0 || now.Sub(e.LastPing) < ttl)
+	enabled := e.Enabled
+	h.mu.Unlock()
+	if fresh {
+		return enabled
+	}
+
+	status := ping()
+	h.setPing(name, status)
+	return status.Healthy
+}
+
+// allow reports whether the circuit lets a request through. An open circuit moves
+// to half-open once the cooldown has passed and lets exactly one probe through.
+func (h *healthRegistry) allow(name string, cooldown time.Duration, now time.Time) bool {
+	e := h.entry(name)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch e.State {
+	case CircuitOpen:
+		if now.Sub(e.OpenedAt) < cooldown {
+			return false
+		}
+		e.State = CircuitHalfOpen
+		e.probing = true
+		return true
+	case CircuitHalfOpen:
+		if e.probing {
+			return false
+		}
+		e.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+func (h *healthRegistry) success(name string) {
+	e := h.entry(name)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e.ConsecutiveFailures = 0
+	e.State = CircuitClosed
+	e.probing = false
+}
+
+func (h *healthRegistry) failure(name string, threshold int, now time.Time) {
+	e := h.entry(name)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e.ConsecutiveFailures++
+	e.probing = false
+	if e.State == CircuitHalfOpen || (threshold > 0 && e.ConsecutiveFailures >= threshold) {
+		e.State = CircuitOpen
+		e.OpenedAt = now
+	}
+}
+
+// release clears a half-open probe that ended without a verdict, e.g. a cancelled request.
+func (h *healthRegistry) release(name string) {
+	e := h.entry(name)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e.probing = false
+}
+
+func (h *healthRegistry) setPing(name string, status HealthStatus) {
+	e := h.entry(name)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if status.CheckedAt.IsZero() {
+		status.CheckedAt = time.Now()
+	}
+	e.Enabled = status.Healthy
+	e.LastPing = status.CheckedAt
+	e.Status = status
+	if status.Err != nil {
+		e.LastError = status.Err
+	}
+}
+
+func (h *healthRegistry) snapshot() []GetterHealth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	health := make([]GetterHealth, 0, len(h.entries))
+	for _, e := range h.entries {
+		health = append(health, e.GetterHealth)
+	}
+	return health
+}
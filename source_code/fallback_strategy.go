@@ -0,0 +1,124 @@
+package source_code
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// Strategy decides the order Fallback tries its getters in for a single request.
+type Strategy interface {
+	Order(getters []SourceGetter) []SourceGetter
+}
+
+// CostedGetter is implemented by getters that charge per request. CostAwareStrategy
+// uses it when no explicit cost is configured for the getter's name.
+type CostedGetter interface {
+	Cost() float64
+}
+
+var _ Strategy = OrderedStrategy{}
+var _ Strategy = &WeightedStrategy{}
+var _ Strategy = &CostAwareStrategy{}
+var _ Strategy = &RaceStrategy{}
+
+// OrderedStrategy tries getters in the order they were given.
+type OrderedStrategy struct{}
+
+func (OrderedStrategy) Order(getters []SourceGetter) []SourceGetter {
+	return getters
+}
+
+// WeightedStrategy shuffles getters so heavier ones tend to go first. Getters without
+// a weight default to 1; a weight of 0 or less always puts the getter last.
+type WeightedStrategy struct {
+	Weights map[string]float64
+}
+
+func NewWeightedStrategy(weights map[string]float64) *WeightedStrategy {
+	return &WeightedStrategy{
+		Weights: weights,
+	}
+}
+
+func (w *WeightedStrategy) weight(getter SourceGetter) float64 {
+	weight, found := w.Weights[getter.Name()]
+	if !found {
+		return 1
+	}
+	return weight
+}
+
+func (w *WeightedStrategy) Order(getters []SourceGetter) []SourceGetter {
+	remaining := make([]SourceGetter, 0, len(getters))
+	var unweighted []SourceGetter
+	for _, getter := range getters {
+		if w.weight(getter) <= 0 {
+			unweighted = append(unweighted, getter)
+			continue
+		}
+		remaining = append(remaining, getter)
+	}
+	ordered := make([]SourceGetter, 0, len(getters))
+	for len(remaining) > 0 {
+		total := 0.0
+		for _, getter := range remaining {
+			total += w.weight(getter)
+		}
+		pick := rand.Float64() * total
+		index := len(remaining) - 1
+		for i, getter := range remaining {
+			pick -= w.weight(getter)
+			if pick < 0 {
+				index = i
+				break
+			}
+		}
+		ordered = append(ordered, remaining[index])
+		remaining = append(remaining[:index], remaining[index+1:]...)
+	}
+	return append(ordered, unweighted...)
+}
+
+// CostAwareStrategy tries the cheapest getters first, keeping the configured order
+// between getters of equal cost, so paid backends are only used when free ones fail.
+type CostAwareStrategy struct {
+	Costs map[string]float64
+}
+
+func NewCostAwareStrategy(costs map[string]float64) *CostAwareStrategy {
+	return &CostAwareStrategy{
+		Costs: costs,
+	}
+}
+
+func (c *CostAwareStrategy) cost(getter SourceGetter) float64 {
+	if cost, found := c.Costs[getter.Name()]; found {
+		return cost
+	}
+	if costed, ok := asGetter[CostedGetter](getter); ok {
+		return costed.Cost()
+	}
+	return 0
+}
+
+func (c *CostAwareStrategy) Order(getters []SourceGetter) []SourceGetter {
+	ordered := make([]SourceGetter, len(getters))
+	copy(ordered, getters)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return c.cost(ordered[i]) < c.cost(ordered[j])
+	})
+	return ordered
+}
+
+// RaceStrategy makes Fallback call every getter at once and keep the first success,
+// cancelling the rest. Strategy, when set, only filters and orders the candidates.
+type RaceStrategy struct {
+	Strategy Strategy
+}
+
+func (r *RaceStrategy) Order(getters []SourceGetter) []SourceGetter {
+	if r.Strategy == nil {
+		return getters
+	}
+	return r.Strategy.Order(getters)
+}
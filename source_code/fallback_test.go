@@ -0,0 +1,247 @@
+package source_code
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var _ SourceGetter = &stubGetter{}
+
+type stubGetter struct {
+	name  string
+	err   error
+	delay time.Duration
+	cost  float64
+	pings atomic.Int32
+	gets  atomic.Int32
+}
+
+func (s *stubGetter) Get(ctx context.Context, endpoint string, options ...SourceOptions) (*SourceResponse, error) {
+	s.gets.Add(1)
+	if s.delay > 0 {
+		select {
+		case <-time.After(s.delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	resp := &SourceResponse{Getter: s.name, StatusCode: http.StatusOK, Body: []byte(s.name)}
+	if s.err != nil {
+		resp.StatusCode = http.StatusForbidden
+	}
+	return resp, s.err
+}
+
+func (s *stubGetter) Ping(ctx context.Context) HealthStatus {
+	s.pings.Add(1)
+	return HealthStatus{Getter: s.name, Healthy: true}
+}
+
+func (s *stubGetter) Enabled() bool {
+	return true
+}
+
+func (s *stubGetter) Name() string {
+	return s.name
+}
+
+func (s *stubGetter) Cost() float64 {
+	return s.cost
+}
+
+func TestFallbackConcurrentPing(t *testing.T) {
+	ctx := context.Background()
+	getter := &stubGetter{name: "stub"}
+	f := NewFallback(getter)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := f.Get(ctx, "https://example.com"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if getter.pings.Load() != 1 {
+		t.Fatalf("expected a single ping within the ttl, got %d", getter.pings.Load())
+	}
+	if getter.gets.Load() != 50 {
+		t.Fatalf("expected 50 gets, got %d", getter.gets.Load())
+	}
+}
+
+func TestFallbackCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	failing := &stubGetter{name: "failing", err: errors.New("connection refused")}
+	working := &stubGetter{name: "working"}
+	f := NewFallback(failing, working)
+	f.FailureThreshold = 2
+	f.Cooldown = 20 * time.Millisecond
+
+	for i := 0; i < 5; i++ {
+		resp, err := f.Get(ctx, "https://example.com")
+		if err != nil || resp.Getter != "working" {
+			t.Fatalf("expected working getter, got %v %v", resp, err)
+		}
+	}
+	if failing.gets.Load() != 2 {
+		t.Fatalf("expected failing getter to be skipped after 2 failures, got %d gets", failing.gets.Load())
+	}
+	health := f.Health()
+	if health[0].Name != "failing" || health[0].State != CircuitOpen {
+		t.Fatalf("expected failing circuit to be open, got %+v", health[0])
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	failing.err = nil
+	resp, err := f.Get(ctx, "https://example.com")
+	if err != nil || resp.Getter != "failing" {
+		t.Fatalf("expected half-open probe to use failing getter, got %v %v", resp, err)
+	}
+	if state := f.Health()[0].State; state != CircuitClosed {
+		t.Fatalf("expected circuit to close after a successful probe, got %s", state)
+	}
+}
+
+func TestFallbackChallengesKeepCircuitClosed(t *testing.T) {
+	ctx := context.Background()
+	challenged := &stubGetter{name: "challenged", err: &ChallengeError{Info: ChallengeInfo{Vendor: VendorCloudflare}}}
+	working := &stubGetter{name: "working"}
+	f := NewFallback(challenged, working)
+	f.FailureThreshold = 2
+
+	for i := 0; i < 5; i++ {
+		if _, err := f.Get(ctx, "https://example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	challenged.err = &ValidationError{Validator: "min-body-size", Reason: "too short"}
+	if _, err := f.Get(ctx, "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if challenged.gets.Load() != 6 {
+		t.Fatalf("expected every Get to try the challenged getter, got %d gets", challenged.gets.Load())
+	}
+	if h := f.Health()[0]; h.Name != "challenged" || h.State != CircuitClosed || h.ConsecutiveFailures != 0 {
+		t.Fatalf("expected challenges to leave the circuit closed, got %+v", h)
+	}
+}
+
+func TestFallbackError(t *testing.T) {
+	ctx := context.Background()
+	decodeErr := errors.New("invalid character")
+	disabled := NewDirect(http.DefaultClient)
+	f := NewFallback(
+		&stubGetter{name: "challenged", err: HasChallengeErr},
+		&stubGetter{name: "decoder", err: decodeErr},
+	)
+	f.Getters = append(f.Getters, &disabledGetter{disabled})
+
+	_, err := f.Get(ctx, "https://example.com")
+	if !errors.Is(err, HasChallengeErr) || !errors.Is(err, decodeErr) || !errors.Is(err, NoFallbackSourceErr) {
+		t.Fatalf("expected wrapped getter errors, got %v", err)
+	}
+	var fallbackErr *FallbackError
+	if !errors.As(err, &fallbackErr) {
+		t.Fatalf("expected *FallbackError, got %T", err)
+	}
+	tried := fallbackErr.Tried()
+	if len(tried) != 2 || tried[0].Getter != "challenged" || tried[0].StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected attempts %+v", tried)
+	}
+	skipped := fallbackErr.Skipped()
+	if len(skipped) != 1 || skipped[0].Getter != disabled.Name() || !errors.Is(skipped[0].Err, NotEnabledErr) {
+		t.Fatalf("unexpected skipped %+v", skipped)
+	}
+}
+
+type disabledGetter struct {
+	SourceGetter
+}
+
+func (d *disabledGetter) Ping(ctx context.Context) HealthStatus {
+	return HealthStatus{Getter: d.Name(), Err: NotEnabledErr}
+}
+
+func (d *disabledGetter) Enabled() bool {
+	return false
+}
+
+func TestFallbackRaceStrategy(t *testing.T) {
+	ctx := context.Background()
+	slow := &stubGetter{name: "slow", delay: time.Second}
+	challenged := &stubGetter{name: "challenged", err: HasChallengeErr}
+	fast := &stubGetter{name: "fast", delay: 10 * time.Millisecond}
+	f := NewFallback(slow, challenged, fast)
+	f.Strategy = &RaceStrategy{}
+
+	start := time.Now()
+	resp, err := f.Get(ctx, "https://example.com")
+	if err != nil || resp.Getter != "fast" {
+		t.Fatalf("expected fast getter to win, got %v %v", resp, err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatal("expected race to return without waiting for the slow getter")
+	}
+	time.Sleep(10 * time.Millisecond)
+	for _, h := range f.Health() {
+		if h.Name == "slow" && h.ConsecutiveFailures != 0 {
+			t.Fatalf("expected cancelled getter to not count as failed, got %+v", h)
+		}
+	}
+}
+
+func TestFallbackCostAwareStrategy(t *testing.T) {
+	ctx := context.Background()
+	zenRows := &stubGetter{name: "zen-rows", cost: 1}
+	direct := &stubGetter{name: "direct", err: HasChallengeErr}
+	flare := &stubGetter{name: "flaresolver"}
+	f := NewFallback(zenRows, direct, flare)
+	f.Strategy = NewCostAwareStrategy(nil)
+
+	resp, err := f.Get(ctx, "https://example.com")
+	if err != nil || resp.Getter != "flaresolver" {
+		t.Fatalf("expected free flaresolver to serve, got %v %v", resp, err)
+	}
+	if zenRows.gets.Load() != 0 {
+		t.Fatal("expected paid getter to not be called")
+	}
+
+	flare.err = HasChallengeErr
+	resp, err = NewFallback(zenRows, direct, flare).Get(ctx, "https://example.com")
+	if err != nil || resp.Getter != "zen-rows" {
+		t.Fatalf("expected escalation to paid getter, got %v %v", resp, err)
+	}
+}
+
+func TestWeightedStrategy(t *testing.T) {
+	getters := []SourceGetter{&stubGetter{name: "a"}, &stubGetter{name: "b"}, &stubGetter{name: "never"}}
+	w := NewWeightedStrategy(map[string]float64{"a": 9, "b": 1, "never": 0})
+	first := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		ordered := w.Order(getters)
+		if len(ordered) != 3 || ordered[2].Name() != "never" {
+			t.Fatalf("expected zero weight getter last, got %v", ordered)
+		}
+		first[ordered[0].Name()]++
+	}
+	if first["a"] < first["b"]*3 {
+		t.Fatalf("expected heavier getter to go first more often, got %v", first)
+	}
+}
+
+type enabledGetter struct {
+	SourceGetter
+}
+
+func (e *enabledGetter) Ping(ctx context.Context) HealthStatus {
+	return HealthStatus{Getter: e.Name(), Healthy: true}
+}
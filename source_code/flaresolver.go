@@ -4,38 +4,87 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
-	"io"
+	"hash/fnv"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var _ SourceGetter = &FlareSolver{}
 
 type FlareSolver struct {
 	client  *http.Client
-	enabled bool
+	enabled atomic.Bool
 	HostURL string
+	// UseSessions keeps one FlareSolverr session per host and proxy so its browser,
+	// and the challenge it already solved, is reused. FlareSolverr only takes a
+	// session's proxy when creating it, so each proxy gets its own session.
+	// SessionTTL lets FlareSolverr rotate them.
+	UseSessions bool
+	SessionTTL  time.Duration
+	// Clearances, when set, receives the cookies and user agent of every solved
+	// request so a Direct sharing the store can fetch the host without solving.
+	Clearances  *ClearanceStore
+	HealthCheck HealthCheck
+
+	sessionsMu sync.Mutex
+	sessions   map[string]string
+	creating   map[string]*sessionCall
+}
+
+// sessionCall is a sessions.create in flight, shared by every request for its session.
+type sessionCall struct {
+	done    chan struct{}
+	session string
+	err     error
 }
 
 type FlareParserRequest struct {
-	Cmd        string `json:"cmd"`
-	Url        string `json:"url"`
-	MaxTimeout int    `json:"maxTimeout"`
+	Cmd               string               `json:"cmd"`
+	Url               string               `json:"url,omitempty"`
+	MaxTimeout        int                  `json:"maxTimeout,omitempty"`
+	Cookies           []FlareRequestCookie `json:"cookies,omitempty"`
+	Session           string               `json:"session,omitempty"`
+	SessionTTLMinutes int                  `json:"session_ttl_minutes,omitempty"`
+	PostData          string               `json:"postData,omitempty"`
+	Proxy             *FlareProxy          `json:"proxy,omitempty"`
+}
+
+var DefaultFlareTimeout = 60 * time.Second
+
+type FlareRequestCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
 }
 
 func FlareSolverFlags() *pflag.FlagSet {
 	fs := pflag.NewFlagSet("flaresolver", pflag.ExitOnError)
 	fs.String("flaresolver-host-url", "http://localhost:8191", "Host URL")
+	fs.Bool("flaresolver-sessions", false, "Reuse one FlareSolverr session per host")
+	fs.Duration("flaresolver-session-ttl", 0, "Rotate FlareSolverr sessions after this long")
+	fs.AddFlagSet(HealthCheckFlags("flaresolver"))
 	return fs
 }
 
-func NewFlareSolverFromFlags(client *http.Client) *FlareSolver {
-	return &FlareSolver{
-		client:  client,
-		HostURL: viper.GetString("flaresolver-host-url"),
+func NewFlareSolverFromFlags(client *http.Client) (*FlareSolver, error) {
+	healthCheck, err := NewHealthCheckWithFlags("flaresolver")
+	if err != nil {
+		return nil, err
 	}
+	return &FlareSolver{
+		client:      client,
+		HostURL:     viper.GetString("flaresolver-host-url"),
+		UseSessions: viper.GetBool("flaresolver-sessions"),
+		SessionTTL:  viper.GetDuration("flaresolver-session-ttl"),
+		HealthCheck: healthCheck,
+	}, nil
 }
 
 func NewFlareSolver(client *http.Client, hostURL string) *FlareSolver {
@@ -45,69 +94,184 @@ func NewFlareSolver(client *http.Client, hostURL string) *FlareSolver {
 	}
 }
 
-func (z *FlareSolver) Get(ctx context.Context, endpoint string, options ...SourceOptions) ([]byte, int, error) {
+func (z *FlareSolver) Get(ctx context.Context, endpoint string, options ...SourceOptions) (*SourceResponse, error) {
+	o := mergeSourceOptions(options...)
+	return o.run(ctx, func(ctx context.Context) (*SourceResponse, error) {
+		return z.get(ctx, endpoint, o)
+	})
+}
+
+func (z *FlareSolver) get(ctx context.Context, endpoint string, o SourceOptions) (*SourceResponse, error) {
+	sr := newSourceResponse(z.Name(), time.Now())
 	if !z.Enabled() {
-		return nil, http.StatusNotImplemented, fmt.Errorf("%s is not enabled", z.Name())
+		sr.StatusCode = http.StatusNotImplemented
+		return sr.done(), fmt.Errorf("%s is %w", z.Name(), NotEnabledErr)
 	}
-	r, err := z.buildRequest(ctx, endpoint)
+	if o.method() != http.MethodGet && o.method() != http.MethodPost {
+		sr.StatusCode = http.StatusMethodNotAllowed
+		return sr.done(), fmt.Errorf("%s does not support method %s", z.Name(), o.method())
+	}
+	session := ""
+	if z.UseSessions {
+		var err error
+		if session, err = z.session(ctx, endpoint, o.Proxy); err != nil {
+			sr.StatusCode = http.StatusServiceUnavailable
+			return sr.done(), err
+		}
+	}
+	r, err := z.buildRequest(ctx, endpoint, session, o)
 	if err != nil {
-		z.enabled = false
-		return nil, http.StatusNotImplemented, err
+		sr.StatusCode = http.StatusBadRequest
+		return sr.done(), fmt.Errorf("%w: %w", InvalidRequestErr, err)
 	}
 	resp, err := z.client.Do(r)
 	if err != nil {
-		return nil, http.StatusNotImplemented, err
+		sr.StatusCode = http.StatusNotImplemented
+		return sr.done(), err
 	}
+	defer resp.Body.Close()
 	responseBody := FlareResponse{}
 	err = json.NewDecoder(resp.Body).Decode(&responseBody)
 	if err != nil {
-		return nil, resp.StatusCode, err
+		sr.StatusCode = resp.StatusCode
+		return sr.done(), err
 	}
-
-	return []byte(responseBody.Solution.Response), resp.StatusCode, nil
-}
-
-func (z *FlareSolver) Ping(ctx context.Context) bool {
-	r, err := z.buildRequest(ctx, PingURL)
-	if err != nil {
-		z.enabled = false
-		return false
+	if responseBody.Status != "ok" {
+		flareErr := newFlareError(FlareCommand(o), responseBody.Message)
+		if errors.Is(flareErr, FlareSessionErr) {
+			z.forgetSession(session)
+		}
+		sr.StatusCode = flareErr.StatusCode()
+		return sr.done(), flareErr
 	}
-	resp, err := z.client.Do(r)
-	if err != nil {
-		z.enabled = false
-		return z.enabled
+	sr.StatusCode = responseBody.Solution.Status
+	sr.Body = []byte(responseBody.Solution.Response)
+	sr.Headers = responseBody.Solution.Headers.HTTPHeader()
+	sr.Cookies = FlareCookies(responseBody.Solution.Cookies).HTTPCookies()
+	sr.FinalURL = responseBody.Solution.Url
+	if sr.FinalURL == "" {
+		sr.FinalURL = endpoint
 	}
-	z.enabled = resp.StatusCode >= http.StatusOK || resp.StatusCode < 300
-	if !z.enabled {
-		return false
+	if err = detectChallengeErr(responseBody.Solution.Status, sr.Headers, sr.Body); err != nil {
+		return sr.done(), err
 	}
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return false
+	if z.Clearances != nil && len(sr.Cookies) > 0 {
+		z.Clearances.Set(endpoint, Clearance{UserAgent: responseBody.Solution.UserAgent, Cookies: sr.Cookies})
 	}
-	if HasChallange(body) {
-		z.enabled = false
-		return false
+	return sr.done(), nil
+}
+
+// Ping checks FlareSolverr's /health endpoint, or solves HealthCheck.URL when it
+// is set and judges the solved page rather than FlareSolverr's reply.
+func (z *FlareSolver) Ping(ctx context.Context) HealthStatus {
+	if z.HealthCheck.URL == "" {
+		status := z.HealthCheck.run(ctx, z.Name(), z.client, func(ctx context.Context) (*http.Request, error) {
+			return http.NewRequestWithContext(ctx, http.MethodGet, z.HostURL+"/health", nil)
+		}, nil)
+		z.enabled.Store(status.Healthy)
+		return status
 	}
-	return z.enabled
+	status := z.HealthCheck.run(ctx, z.Name(), z.client, func(ctx context.Context) (*http.Request, error) {
+		return z.buildRequest(ctx, z.HealthCheck.URL, "", SourceOptions{})
+	}, readFlareHealth)
+	z.enabled.Store(status.Healthy)
+	return status
+}
 
+// readFlareHealth unwraps the page FlareSolverr solved from its JSON reply.
+func readFlareHealth(resp *http.Response) (int, http.Header, []byte, error) {
+	flare := FlareResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&flare); err != nil {
+		return resp.StatusCode, resp.Header, nil, err
+	}
+	if flare.Status != "ok" {
+		flareErr := newFlareError(FlareCommand(SourceOptions{}), flare.Message)
+		return flareErr.StatusCode(), resp.Header, nil, flareErr
+	}
+	return flare.Solution.Status, flare.Solution.Headers.HTTPHeader(), []byte(flare.Solution.Response), nil
 }
 
 func (z *FlareSolver) Enabled() bool {
-	return z.enabled
+	return z.enabled.Load()
+}
+
+// HandlesChallenge reports whether FlareSolverr's browser can solve the challenge,
+// which covers Cloudflare and other javascript challenges but not captchas.
+func (z *FlareSolver) HandlesChallenge(info ChallengeInfo) bool {
+	return info.Vendor == VendorCloudflare || info.Kind == ChallengeKindJS
 }
 
 func (z *FlareSolver) Name() string {
 	return "flaresolver"
 }
 
-func (z *FlareSolver) buildRequest(ctx context.Context, endpoint string) (*http.Request, error) {
+// FlareCommand is the FlareSolverr command used for a request with these options.
+func FlareCommand(o SourceOptions) string {
+	if o.method() == http.MethodPost {
+		return "request.post"
+	}
+	return "request.get"
+}
+
+// buildRequest only forwards cookies, the body and the proxy from the options;
+// FlareSolverr drives its own browser so custom headers and user agents cannot be
+// set per request. POST bodies must be application/x-www-form-urlencoded. The
+// proxy is left out when a session is used, since the session already has one.
+func (z *FlareSolver) buildRequest(ctx context.Context, endpoint, session string, o SourceOptions) (*http.Request, error) {
 	body := FlareParserRequest{
-		Cmd:        "request.get",
+		Cmd:        FlareCommand(o),
 		Url:        endpoint,
-		MaxTimeout: 60000,
+		MaxTimeout: int(DefaultFlareTimeout.Milliseconds()),
+		Session:    session,
 	}
+	if o.SolveTimeout > 0 {
+		body.MaxTimeout = int(o.SolveTimeout.Milliseconds())
+	}
+	if body.Cmd == "request.post" {
+		if ct := o.Headers.Get("Content-Type"); ct != "" && !strings.HasPrefix(ct, "application/x-www-form-urlencoded") {
+			return nil, fmt.Errorf("%s only posts form data, got %s", z.Name(), ct)
+		}
+		body.PostData = string(o.Body)
+	}
+	if o.Proxy != "" && session == "" {
+		proxy, err := newFlareProxy(o.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		body.Proxy = proxy
+	}
+	if session != "" && z.SessionTTL > 0 {
+		body.SessionTTLMinutes = int(z.SessionTTL.Minutes())
+	}
+	for _, c := range o.Cookies {
+		body.Cookies = append(body.Cookies, FlareRequestCookie{Name: c.Name, Value: c.Value})
+	}
+	return z.newRequest(ctx, body)
+}
+
+// FlareProxy is FlareSolverr's proxy setting; credentials are sent separately from the URL.
+type FlareProxy struct {
+	Url      string `json:"url"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+func newFlareProxy(raw string) (*FlareProxy, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy: %w", err)
+	}
+	proxy := &FlareProxy{}
+	if u.User != nil {
+		proxy.Username = u.User.Username()
+		proxy.Password, _ = u.User.Password()
+		u.User = nil
+	}
+	proxy.Url = u.String()
+	return proxy, nil
+}
+
+func (z *FlareSolver) newRequest(ctx context.Context, body FlareParserRequest) (*http.Request, error) {
 	b, err := json.Marshal(body)
 	if err != nil {
 		return nil, err
@@ -122,43 +286,239 @@ func (z *FlareSolver) buildRequest(ctx context.Context, endpoint string) (*http.
 	return req, nil
 }
 
+// command sends a non-request command such as sessions.create to FlareSolverr.
+func (z *FlareSolver) command(ctx context.Context, body FlareParserRequest) (*FlareResponse, error) {
+	r, err := z.newRequest(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := z.client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	responseBody := &FlareResponse{}
+	if err = json.NewDecoder(resp.Body).Decode(responseBody); err != nil {
+		return nil, err
+	}
+	if responseBody.Status != "ok" {
+		return responseBody, newFlareError(body.Cmd, responseBody.Message)
+	}
+	return responseBody, nil
+}
+
+// CreateSession starts a FlareSolverr browser session, letting FlareSolverr pick the id when id is empty.
+func (z *FlareSolver) CreateSession(ctx context.Context, id string) (string, error) {
+	return z.createSession(ctx, id, "")
+}
+
+// createSession starts a session whose browser goes through proxy, when set.
+func (z *FlareSolver) createSession(ctx context.Context, id, proxy string) (string, error) {
+	body := FlareParserRequest{Cmd: "sessions.create", Session: id}
+	if proxy != "" {
+		var err error
+		if body.Proxy, err = newFlareProxy(proxy); err != nil {
+			return "", fmt.Errorf("%w: %w", InvalidRequestErr, err)
+		}
+	}
+	resp, err := z.command(ctx, body)
+	if err != nil {
+		return "", err
+	}
+	return resp.Session, nil
+}
+
+func (z *FlareSolver) ListSessions(ctx context.Context) ([]string, error) {
+	resp, err := z.command(ctx, FlareParserRequest{Cmd: "sessions.list"})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Sessions, nil
+}
+
+func (z *FlareSolver) DestroySession(ctx context.Context, id string) error {
+	z.forgetSession(id)
+	_, err := z.command(ctx, FlareParserRequest{Cmd: "sessions.destroy", Session: id})
+	return err
+}
+
+// DestroySessions destroys every session in the per-host pool.
+func (z *FlareSolver) DestroySessions(ctx context.Context) error {
+	z.sessionsMu.Lock()
+	sessions := z.sessions
+	z.sessions = nil
+	z.sessionsMu.Unlock()
+	var errs []error
+	for _, session := range sessions {
+		if _, err := z.command(ctx, FlareParserRequest{Cmd: "sessions.destroy", Session: session}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// forgetSession drops id from the pool so the next request for its host creates a new one.
+func (z *FlareSolver) forgetSession(id string) {
+	if id == "" {
+		return
+	}
+	z.sessionsMu.Lock()
+	defer z.sessionsMu.Unlock()
+	for key, session := range z.sessions {
+		if session == id {
+			delete(z.sessions, key)
+		}
+	}
+}
+
+// session returns the pooled session for endpoint's host and proxy, creating it
+// on first use. The lock is not held while FlareSolverr starts the browser, so
+// other hosts are not held up; requests for the same session wait for it.
+func (z *FlareSolver) session(ctx context.Context, endpoint, proxy string) (string, error) {
+	id := "wp-" + routeHost(endpoint)
+	if proxy != "" {
+		// The id is sent to FlareSolverr and shows up in its logs, so hash
+		// the proxy rather than include its credentials.
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(proxy))
+		id += fmt.Sprintf("-%08x", h.Sum32())
+	}
+	z.sessionsMu.Lock()
+	if session, found := z.sessions[id]; found {
+		z.sessionsMu.Unlock()
+		return session, nil
+	}
+	if call, found := z.creating[id]; found {
+		z.sessionsMu.Unlock()
+		select {
+		case <-call.done:
+			return call.session, call.err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	call := &sessionCall{done: make(chan struct{})}
+	if z.creating == nil {
+		z.creating = make(map[string]*sessionCall)
+	}
+	z.creating[id] = call
+	z.sessionsMu.Unlock()
+
+	call.session, call.err = z.createSession(ctx, id, proxy)
+	z.sessionsMu.Lock()
+	delete(z.creating, id)
+	if call.err == nil {
+		if z.sessions == nil {
+			z.sessions = make(map[string]string)
+		}
+		z.sessions[id] = call.session
+	}
+	z.sessionsMu.Unlock()
+	close(call.done)
+	return call.session, call.err
+}
+
 type FlareResponse struct {
 	Solution struct {
-		Url     string `json:"url"`
-		Status  int    `json:"status"`
-		Headers struct {
-			Status                  string `json:"status"`
-			Date                    string `json:"date"`
-			Expires                 string `json:"expires"`
-			CacheControl            string `json:"cache-control"`
-			ContentType             string `json:"content-type"`
-			StrictTransportSecurity string `json:"strict-transport-security"`
-			P3P                     string `json:"p3p"`
-			ContentEncoding         string `json:"content-encoding"`
-			Server                  string `json:"server"`
-			ContentLength           string `json:"content-length"`
-			XXssProtection          string `json:"x-xss-protection"`
-			XFrameOptions           string `json:"x-frame-options"`
-			SetCookie               string `json:"set-cookie"`
-		} `json:"headers"`
-		Response string `json:"response"`
-		Cookies  []struct {
-			Name     string  `json:"name"`
-			Value    string  `json:"value"`
-			Domain   string  `json:"domain"`
-			Path     string  `json:"path"`
-			Expires  float64 `json:"expires"`
-			Size     int     `json:"size"`
-			HttpOnly bool    `json:"httpOnly"`
-			Secure   bool    `json:"secure"`
-			Session  bool    `json:"session"`
-			SameSite string  `json:"sameSite"`
-		} `json:"cookies"`
-		UserAgent string `json:"userAgent"`
+		Url       string        `json:"url"`
+		Status    int           `json:"status"`
+		Headers   FlareHeaders  `json:"headers"`
+		Response  string        `json:"response"`
+		Cookies   []FlareCookie `json:"cookies"`
+		UserAgent string        `json:"userAgent"`
 	} `json:"solution"`
-	Status         string `json:"status"`
-	Message        string `json:"message"`
-	StartTimestamp int64  `json:"startTimestamp"`
-	EndTimestamp   int64  `json:"endTimestamp"`
-	Version        string `json:"version"`
+	Session        string   `json:"session,omitempty"`
+	Sessions       []string `json:"sessions,omitempty"`
+	Status         string   `json:"status"`
+	Message        string   `json:"message"`
+	StartTimestamp int64    `json:"startTimestamp"`
+	EndTimestamp   int64    `json:"endTimestamp"`
+	Version        string   `json:"version"`
+}
+
+type FlareHeaders struct {
+	Status                  string `json:"status"`
+	Date                    string `json:"date"`
+	Expires                 string `json:"expires"`
+	CacheControl            string `json:"cache-control"`
+	ContentType             string `json:"content-type"`
+	StrictTransportSecurity string `json:"strict-transport-security"`
+	P3P                     string `json:"p3p"`
+	ContentEncoding         string `json:"content-encoding"`
+	Server                  string `json:"server"`
+	ContentLength           string `json:"content-length"`
+	XXssProtection          string `json:"x-xss-protection"`
+	XFrameOptions           string `json:"x-frame-options"`
+	SetCookie               string `json:"set-cookie"`
+}
+
+// HTTPHeader converts the parsed solution headers into an http.Header, skipping empty values.
+func (h FlareHeaders) HTTPHeader() http.Header {
+	header := http.Header{}
+	for key, value := range map[string]string{
+		"Status":                    h.Status,
+		"Date":                      h.Date,
+		"Expires":                   h.Expires,
+		"Cache-Control":             h.CacheControl,
+		"Content-Type":              h.ContentType,
+		"Strict-Transport-Security": h.StrictTransportSecurity,
+		"P3P":                       h.P3P,
+		"Content-Encoding":          h.ContentEncoding,
+		"Server":                    h.Server,
+		"Content-Length":            h.ContentLength,
+		"X-Xss-Protection":          h.XXssProtection,
+		"X-Frame-Options":           h.XFrameOptions,
+	} {
+		if value != "" {
+			header.Set(key, value)
+		}
+	}
+	for _, cookie := range strings.Split(h.SetCookie, "\n") {
+		if cookie != "" {
+			header.Add("Set-Cookie", cookie)
+		}
+	}
+	return header
+}
+
+type FlareCookie struct {
+	Name     string  `json:"name"`
+	Value    string  `json:"value"`
+	Domain   string  `json:"domain"`
+	Path     string  `json:"path"`
+	Expires  float64 `json:"expires"`
+	Size     int     `json:"size"`
+	HttpOnly bool    `json:"httpOnly"`
+	Secure   bool    `json:"secure"`
+	Session  bool    `json:"session"`
+	SameSite string  `json:"sameSite"`
+}
+
+type FlareCookies []FlareCookie
+
+func (c FlareCookies) HTTPCookies() []*http.Cookie {
+	cookies := make([]*http.Cookie, 0, len(c))
+	for _, fc := range c {
+		cookie := &http.Cookie{
+			Name:     fc.Name,
+			Value:    fc.Value,
+			Domain:   fc.Domain,
+			Path:     fc.Path,
+			HttpOnly: fc.HttpOnly,
+			Secure:   fc.Secure,
+		}
+		if !fc.Session && fc.Expires > 0 {
+			cookie.Expires = time.Unix(int64(fc.Expires), 0)
+		}
+		switch strings.ToLower(fc.SameSite) {
+		case "lax":
+			cookie.SameSite = http.SameSiteLaxMode
+		case "strict":
+			cookie.SameSite = http.SameSiteStrictMode
+		case "none":
+			cookie.SameSite = http.SameSiteNoneMode
+		}
+		cookies = append(cookies, cookie)
+	}
+	return cookies
 }
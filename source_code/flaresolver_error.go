@@ -0,0 +1,56 @@
+package source_code
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	FlareTimeoutErr   = errors.New("flaresolver timed out")
+	FlareChallengeErr = fmt.Errorf("flaresolver could not solve the challenge: %w", HasChallengeErr)
+	FlareSessionErr   = errors.New("flaresolver session does not exist")
+)
+
+// FlareError is returned when FlareSolverr answers with status "error". Err is
+// one of the Flare*Err sentinels when the message is recognised.
+type FlareError struct {
+	Cmd     string
+	Message string
+	Err     error
+}
+
+func (e *FlareError) Error() string {
+	return fmt.Sprintf("flaresolver %s: %s", e.Cmd, e.Message)
+}
+
+func (e *FlareError) Unwrap() error {
+	return e.Err
+}
+
+// StatusCode is the status reported for a failed command.
+func (e *FlareError) StatusCode() int {
+	switch {
+	case errors.Is(e.Err, FlareTimeoutErr):
+		return http.StatusGatewayTimeout
+	case errors.Is(e.Err, FlareChallengeErr):
+		return http.StatusForbidden
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+func newFlareError(cmd, message string) *FlareError {
+	e := &FlareError{Cmd: cmd, Message: message}
+	lower := strings.ToLower(message)
+	switch {
+	case strings.Contains(lower, "session") && (strings.Contains(lower, "doesn't exist") || strings.Contains(lower, "does not exist") || strings.Contains(lower, "not found")):
+		e.Err = FlareSessionErr
+	case strings.Contains(lower, "timeout"):
+		e.Err = FlareTimeoutErr
+	case strings.Contains(lower, "challenge") || strings.Contains(lower, "captcha") || strings.Contains(lower, "blocked"):
+		e.Err = FlareChallengeErr
+	}
+	return e
+}
@@ -0,0 +1,188 @@
+package source_code
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// HAR is an HTTP Archive 1.2 document, see http://www.softwareishard.com/blog/har-12-spec/.
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+	// Comment notes where the log came from, such as a fixture written by hand.
+	Comment string `json:"comment,omitempty"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type HAREntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	Getter          string      `json:"_getter,omitempty"`
+	Error           string      `json:"_error,omitempty"`
+}
+
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+	FinalURL    string         `json:"_finalURL,omitempty"`
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARCookie struct {
+	Name     string     `json:"name"`
+	Value    string     `json:"value"`
+	Path     string     `json:"path,omitempty"`
+	Domain   string     `json:"domain,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	HTTPOnly bool       `json:"httpOnly,omitempty"`
+	Secure   bool       `json:"secure,omitempty"`
+}
+
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type HARContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type HARTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+func NewHAR() *HAR {
+	return &HAR{Log: HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "github.com/Seann-Moser/wp", Version: "1.0"},
+		Entries: []HAREntry{},
+	}}
+}
+
+func LoadHAR(path string) (*HAR, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	har := &HAR{}
+	if err = json.Unmarshal(b, har); err != nil {
+		return nil, err
+	}
+	return har, nil
+}
+
+func (h *HAR) Save(path string) error {
+	b, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b)
+}
+
+func harHeaders(headers http.Header) []HARNameValue {
+	values := []HARNameValue{}
+	for name, vs := range headers {
+		for _, v := range vs {
+			values = append(values, HARNameValue{Name: name, Value: v})
+		}
+	}
+	return values
+}
+
+func (h HARResponse) header() http.Header {
+	header := http.Header{}
+	for _, nv := range h.Headers {
+		header.Add(nv.Name, nv.Value)
+	}
+	return header
+}
+
+func harCookies(cookies []*http.Cookie) []HARCookie {
+	values := []HARCookie{}
+	for _, c := range cookies {
+		hc := HARCookie{Name: c.Name, Value: c.Value, Path: c.Path, Domain: c.Domain, HTTPOnly: c.HttpOnly, Secure: c.Secure}
+		if !c.Expires.IsZero() {
+			expires := c.Expires
+			hc.Expires = &expires
+		}
+		values = append(values, hc)
+	}
+	return values
+}
+
+func (h HARResponse) cookies() []*http.Cookie {
+	cookies := make([]*http.Cookie, 0, len(h.Cookies))
+	for _, c := range h.Cookies {
+		cookie := &http.Cookie{Name: c.Name, Value: c.Value, Path: c.Path, Domain: c.Domain, HttpOnly: c.HTTPOnly, Secure: c.Secure}
+		if c.Expires != nil {
+			cookie.Expires = *c.Expires
+		}
+		cookies = append(cookies, cookie)
+	}
+	return cookies
+}
+
+// harContent stores text bodies as is and anything that is not valid UTF-8 as base64.
+func harContent(body []byte, mimeType string) HARContent {
+	content := HARContent{Size: len(body), MimeType: mimeType}
+	if utf8.Valid(body) {
+		content.Text = string(body)
+		return content
+	}
+	content.Text = base64.StdEncoding.EncodeToString(body)
+	content.Encoding = "base64"
+	return content
+}
+
+func (h HARContent) body() ([]byte, error) {
+	if strings.EqualFold(h.Encoding, "base64") {
+		return base64.StdEncoding.DecodeString(h.Text)
+	}
+	return []byte(h.Text), nil
+}
@@ -0,0 +1,160 @@
+package source_code
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// maxHeaderBlock is how much of a request orderedConn buffers looking for the end
+// of its headers before giving up and sending it as written.
+const maxHeaderBlock = 64 << 10
+
+// orderHeaders makes t write every HTTP/1.1 request's headers in order. net/http
+// sorts headers by key, so the transport's connections are wrapped and each header
+// block is rearranged on its way to the wire. HTTP/2 is turned off, since its
+// frames cannot be rearranged this way. Through a proxy to an https site the
+// transport encrypts the tunnel itself and the headers keep Go's order.
+func orderHeaders(t *http.Transport, order []string) {
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	config := t.TLSClientConfig
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return newOrderedConn(conn, order), nil
+	}
+	t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		c := &tls.Config{}
+		if config != nil {
+			c = config.Clone()
+		}
+		if c.ServerName == "" {
+			c.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		c.NextProtos = []string{"http/1.1"}
+		tlsConn := tls.Client(conn, c)
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		return newOrderedConn(tlsConn, order), nil
+	}
+	t.ForceAttemptHTTP2 = false
+	t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+}
+
+// orderedConn buffers each request until the end of its headers and writes them
+// in order. A response being read marks the start of the next request; requests
+// sending Expect: 100-continue or CONNECT leave the rest of the connection alone.
+type orderedConn struct {
+	net.Conn
+	order []string
+
+	mu          sync.Mutex
+	header      bool
+	passthrough bool
+	buf         []byte
+	// sent and responded are shared with Read, which must not wait on a Write.
+	sent      atomic.Bool
+	responded atomic.Bool
+}
+
+func newOrderedConn(conn net.Conn, order []string) *orderedConn {
+	return &orderedConn{Conn: conn, order: order, header: true}
+}
+
+func (c *orderedConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.passthrough {
+		return c.Conn.Write(p)
+	}
+	if !c.header && c.responded.Load() {
+		c.header = true
+		c.sent.Store(false)
+		c.responded.Store(false)
+	}
+	if !c.header {
+		return c.Conn.Write(p)
+	}
+	c.buf = append(c.buf, p...)
+	end := bytes.Index(c.buf, []byte("\r\n\r\n"))
+	if end < 0 {
+		if len(c.buf) > maxHeaderBlock {
+			c.passthrough = true
+			return len(p), c.flush(c.buf)
+		}
+		return len(p), nil
+	}
+	block, rest := c.buf[:end+2], c.buf[end+2:]
+	c.header = false
+	c.sent.Store(true)
+	lower := strings.ToLower(string(block))
+	if strings.HasPrefix(lower, "connect ") || strings.Contains(lower, "\r\nexpect:") {
+		c.passthrough = true
+	}
+	out := append(reorderHeaderBlock(block, c.order), rest...)
+	return len(p), c.flush(out)
+}
+
+func (c *orderedConn) flush(b []byte) error {
+	c.buf = nil
+	_, err := c.Conn.Write(b)
+	return err
+}
+
+func (c *orderedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 && c.sent.Load() {
+		c.responded.Store(true)
+	}
+	return n, err
+}
+
+// reorderHeaderBlock moves the header lines named in order, in that order, to
+// just after the request line. Headers not named follow in the order written.
+func reorderHeaderBlock(block []byte, order []string) []byte {
+	lines := strings.Split(strings.TrimSuffix(string(block), "\r\n"), "\r\n")
+	if len(lines) < 2 {
+		return block
+	}
+	headers := lines[1:]
+	used := make([]bool, len(headers))
+	sorted := make([]string, 0, len(headers))
+	for _, key := range order {
+		for i, line := range headers {
+			name, _, _ := strings.Cut(line, ":")
+			if !used[i] && strings.EqualFold(strings.TrimSpace(name), key) {
+				used[i] = true
+				sorted = append(sorted, line)
+			}
+		}
+	}
+	for i, line := range headers {
+		if !used[i] {
+			sorted = append(sorted, line)
+		}
+	}
+	var b strings.Builder
+	b.WriteString(lines[0])
+	b.WriteString("\r\n")
+	for _, line := range sorted {
+		b.WriteString(line)
+		b.WriteString("\r\n")
+	}
+	return []byte(b.String())
+}
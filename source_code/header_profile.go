@@ -0,0 +1,135 @@
+package source_code
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ProfileHeader is one header a browser sends on a top-level navigation.
+type ProfileHeader struct {
+	Key   string
+	Value string
+}
+
+// HeaderProfile makes Direct's requests look like a browser navigation.
+// Accept-Encoding is left to the transport so responses are still decompressed.
+type HeaderProfile struct {
+	Name       string
+	UserAgents []string
+	Headers    []ProfileHeader
+	// Order is the browser's header order on the wire. When set, Direct sends
+	// over HTTP/1.1 through a transport that writes headers in this order instead
+	// of sorted by key; headers not listed follow. It needs an *http.Transport.
+	Order []string
+}
+
+var ChromeProfile = HeaderProfile{
+	Name: "chrome",
+	UserAgents: []string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36",
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36",
+	},
+	Headers: []ProfileHeader{
+		{"Sec-Ch-Ua", `"Google Chrome";v="129", "Not=A?Brand";v="8", "Chromium";v="129"`},
+		{"Sec-Ch-Ua-Mobile", "?0"},
+		{"Upgrade-Insecure-Requests", "1"},
+		{"Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7"},
+		{"Sec-Fetch-Site", "none"},
+		{"Sec-Fetch-Mode", "navigate"},
+		{"Sec-Fetch-User", "?1"},
+		{"Sec-Fetch-Dest", "document"},
+		{"Accept-Language", "en-US,en;q=0.9"},
+		{"Priority", "u=0, i"},
+	},
+	Order: []string{
+		"Host", "Connection", "Sec-Ch-Ua", "Sec-Ch-Ua-Mobile", "Upgrade-Insecure-Requests", "User-Agent",
+		"Accept", "Sec-Fetch-Site", "Sec-Fetch-Mode", "Sec-Fetch-User", "Sec-Fetch-Dest",
+		"Accept-Encoding", "Accept-Language", "Cookie", "Priority",
+	},
+}
+
+var FirefoxProfile = HeaderProfile{
+	Name: "firefox",
+	UserAgents: []string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:131.0) Gecko/20100101 Firefox/131.0",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 14.7; rv:131.0) Gecko/20100101 Firefox/131.0",
+		"Mozilla/5.0 (X11; Linux x86_64; rv:131.0) Gecko/20100101 Firefox/131.0",
+	},
+	Headers: []ProfileHeader{
+		{"Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/png,image/svg+xml,*/*;q=0.8"},
+		{"Accept-Language", "en-US,en;q=0.5"},
+		{"Upgrade-Insecure-Requests", "1"},
+		{"Sec-Fetch-Dest", "document"},
+		{"Sec-Fetch-Mode", "navigate"},
+		{"Sec-Fetch-Site", "none"},
+		{"Sec-Fetch-User", "?1"},
+		{"Priority", "u=0, i"},
+		{"Te", "trailers"},
+	},
+	Order: []string{
+		"Host", "User-Agent", "Accept", "Accept-Language", "Accept-Encoding", "Connection", "Cookie",
+		"Upgrade-Insecure-Requests", "Sec-Fetch-Dest", "Sec-Fetch-Mode", "Sec-Fetch-Site", "Sec-Fetch-User",
+		"Priority", "Te",
+	},
+}
+
+var SafariProfile = HeaderProfile{
+	Name: "safari",
+	UserAgents: []string{
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/18.0 Safari/605.1.15",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 18_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/18.0 Mobile/15E148 Safari/604.1",
+	},
+	Headers: []ProfileHeader{
+		{"Sec-Fetch-Dest", "document"},
+		{"Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+		{"Sec-Fetch-Site", "none"},
+		{"Sec-Fetch-Mode", "navigate"},
+		{"Accept-Language", "en-US,en;q=0.9"},
+		{"Priority", "u=0, i"},
+	},
+	Order: []string{
+		"Host", "Sec-Fetch-Dest", "User-Agent", "Accept", "Sec-Fetch-Site", "Sec-Fetch-Mode",
+		"Accept-Language", "Priority", "Accept-Encoding", "Connection", "Cookie",
+	},
+}
+
+var headerProfiles = map[string]HeaderProfile{
+	ChromeProfile.Name:  ChromeProfile,
+	FirefoxProfile.Name: FirefoxProfile,
+	SafariProfile.Name:  SafariProfile,
+}
+
+// RegisterHeaderProfile adds or replaces a profile selectable by name.
+func RegisterHeaderProfile(profile HeaderProfile) {
+	headerProfiles[strings.ToLower(profile.Name)] = profile
+}
+
+// LookupHeaderProfile returns the profile registered under name.
+func LookupHeaderProfile(name string) (HeaderProfile, error) {
+	profile, found := headerProfiles[strings.ToLower(name)]
+	if !found {
+		names := make([]string, 0, len(headerProfiles))
+		for n := range headerProfiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return HeaderProfile{}, fmt.Errorf("unknown header profile %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+	return profile, nil
+}
+
+// apply sets the profile's headers and userAgent on r, leaving any header the
+// request already has alone.
+func (p *HeaderProfile) apply(r *http.Request, userAgent string) {
+	for _, h := range p.Headers {
+		if r.Header.Get(h.Key) == "" {
+			r.Header.Set(h.Key, h.Value)
+		}
+	}
+	if userAgent != "" && r.Header.Get("User-Agent") == "" {
+		r.Header.Set("User-Agent", userAgent)
+	}
+}
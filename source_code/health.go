@@ -0,0 +1,148 @@
+package source_code
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+var DefaultHealthTimeout = 10 * time.Second
+
+// HealthCheck configures how a getter's Ping decides it is usable. Without a URL
+// getters use their native endpoint (FlareSolverr's /health, ZenRows' usage API,
+// PingURL for Direct); services fetch an explicit URL through themselves. The
+// status must fall in MinStatus..MaxStatus, 2xx by default, and the body must
+// match BodyPattern when it is set. Services judge the target's status and body,
+// not their own response wrapping it.
+type HealthCheck struct {
+	URL         string
+	MinStatus   int
+	MaxStatus   int
+	BodyPattern *regexp.Regexp
+	Timeout     time.Duration
+}
+
+func HealthCheckFlags(prefix string) *pflag.FlagSet {
+	fs := pflag.NewFlagSet(GetFlagWithPrefix("health", prefix), pflag.ExitOnError)
+	fs.String(GetFlagWithPrefix("health-url", prefix), "", "URL to check instead of the getter's native health endpoint")
+	fs.Int(GetFlagWithPrefix("health-min-status", prefix), http.StatusOK, "lowest healthy status code")
+	fs.Int(GetFlagWithPrefix("health-max-status", prefix), 299, "highest healthy status code")
+	fs.String(GetFlagWithPrefix("health-body-pattern", prefix), "", "regular expression the health response body must match")
+	fs.Duration(GetFlagWithPrefix("health-timeout", prefix), DefaultHealthTimeout, "timeout for a health check")
+	return fs
+}
+
+func NewHealthCheckWithFlags(prefix string) (HealthCheck, error) {
+	hc := HealthCheck{
+		URL:       viper.GetString(GetFlagWithPrefix("health-url", prefix)),
+		MinStatus: viper.GetInt(GetFlagWithPrefix("health-min-status", prefix)),
+		MaxStatus: viper.GetInt(GetFlagWithPrefix("health-max-status", prefix)),
+		Timeout:   viper.GetDuration(GetFlagWithPrefix("health-timeout", prefix)),
+	}
+	var err error
+	hc.BodyPattern, err = compileBodyPattern(viper.GetString(GetFlagWithPrefix("health-body-pattern", prefix)))
+	return hc, err
+}
+
+func compileBodyPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid health body pattern: %w", err)
+	}
+	return re, nil
+}
+
+// HealthStatus is the result of a Ping.
+type HealthStatus struct {
+	Getter     string
+	Healthy    bool
+	StatusCode int
+	Latency    time.Duration
+	CheckedAt  time.Time
+	Err        error
+}
+
+func (h HealthStatus) String() string {
+	if h.Healthy {
+		return fmt.Sprintf("%s healthy in %s", h.Getter, h.Latency)
+	}
+	return fmt.Sprintf("%s unhealthy after %s: %v", h.Getter, h.Latency, h.Err)
+}
+
+// healthRead turns a health check response into the status, headers and body to judge.
+type healthRead func(resp *http.Response) (int, http.Header, []byte, error)
+
+// readHealth judges the response as it is.
+func readHealth(resp *http.Response) (int, http.Header, []byte, error) {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPingBytes))
+	return resp.StatusCode, resp.Header, body, err
+}
+
+// run sends the request from build with client and judges what read returns.
+func (hc HealthCheck) run(ctx context.Context, getter string, client *http.Client, build func(ctx context.Context) (*http.Request, error), read healthRead) (status HealthStatus) {
+	status = HealthStatus{Getter: getter, CheckedAt: time.Now()}
+	timeout := hc.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHealthTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	defer func() {
+		status.Latency = time.Since(status.CheckedAt)
+	}()
+
+	r, err := build(ctx)
+	if err != nil {
+		status.Err = err
+		return status
+	}
+	resp, err := client.Do(r)
+	if err != nil {
+		status.Err = err
+		return status
+	}
+	defer resp.Body.Close()
+	if read == nil {
+		read = readHealth
+	}
+	code, header, body, err := read(resp)
+	status.StatusCode = code
+	if err != nil {
+		status.Err = err
+		return status
+	}
+	if err = hc.judge(code, header, body); err != nil {
+		status.Err = err
+		return status
+	}
+	status.Healthy = true
+	return status
+}
+
+func (hc HealthCheck) judge(code int, header http.Header, body []byte) error {
+	minStatus, maxStatus := hc.MinStatus, hc.MaxStatus
+	if minStatus == 0 {
+		minStatus = http.StatusOK
+	}
+	if maxStatus == 0 {
+		maxStatus = 299
+	}
+	if code < minStatus || code > maxStatus {
+		return fmt.Errorf("health check status %d outside %d-%d", code, minStatus, maxStatus)
+	}
+	if err := detectChallengeErr(code, header, body); err != nil {
+		return err
+	}
+	if hc.BodyPattern != nil && !hc.BodyPattern.Match(body) {
+		return fmt.Errorf("health check body does not match %s", hc.BodyPattern)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package source_code
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthCheck(t *testing.T) {
+	ctx := context.Background()
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.RequestURI())
+		switch r.URL.Path {
+		case "/down":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/health":
+			_, _ = w.Write([]byte(`{"msg": "FlareSolverr is ready!"}`))
+		case "/v1/usage":
+			_, _ = w.Write([]byte(`{"usage": 10}`))
+		default:
+			_, _ = w.Write([]byte("<html>ok</html>"))
+		}
+	}))
+	defer server.Close()
+
+	d := NewDirect(server.Client())
+	d.HealthCheck = HealthCheck{URL: server.URL + "/down"}
+	if status := d.Ping(ctx); status.Healthy || status.StatusCode != http.StatusInternalServerError || status.Err == nil || d.Enabled() {
+		t.Fatalf("expected a 500 to be unhealthy, got %+v", status)
+	}
+	d.HealthCheck = HealthCheck{URL: server.URL + "/down", MinStatus: 200, MaxStatus: 599}
+	if status := d.Ping(ctx); !status.Healthy || !d.Enabled() {
+		t.Fatalf("expected a widened status range to be healthy, got %+v", status)
+	}
+	d.HealthCheck = HealthCheck{URL: server.URL + "/page", BodyPattern: regexp.MustCompile("ready")}
+	if status := d.Ping(ctx); status.Healthy {
+		t.Fatalf("expected a body mismatch to be unhealthy, got %+v", status)
+	}
+
+	f := NewFlareSolver(server.Client(), server.URL)
+	f.HealthCheck.BodyPattern = regexp.MustCompile("ready")
+	if status := f.Ping(ctx); !status.Healthy || status.Getter != "flaresolver" || status.Latency <= 0 {
+		t.Fatalf("unexpected flaresolver health %+v", status)
+	}
+
+	z := NewZenRows(server.Client(), "key", false)
+	z.HostURL = server.URL + "/v1/"
+	if status := z.Ping(ctx); !status.Healthy {
+		t.Fatalf("unexpected zenrows health %+v", status)
+	}
+	if last := paths[len(paths)-1]; last != "/v1/usage?apikey=key" {
+		t.Fatalf("expected the usage endpoint, got %s", last)
+	}
+}
+
+func TestFlareSolverHealthURL(t *testing.T) {
+	ctx := context.Background()
+	var solution atomic.Value
+	solution.Store(`{"status": 503, "response": "<html>down</html>"}`)
+	flare := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, `{"status": "ok", "solution": %s}`, solution.Load())
+	}))
+	defer flare.Close()
+
+	f := NewFlareSolver(flare.Client(), flare.URL)
+	f.HealthCheck = HealthCheck{URL: "https://example.com/", BodyPattern: regexp.MustCompile("ready")}
+	if status := f.Ping(ctx); status.Healthy || status.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected the solved page's 503 to be unhealthy, got %+v", status)
+	}
+	solution.Store(`{"status": 200, "response": "<html>status</html>"}`)
+	if status := f.Ping(ctx); status.Healthy {
+		t.Fatalf("expected the solved page to be matched against the pattern, got %+v", status)
+	}
+	solution.Store(`{"status": 200, "response": "<html>ready</html>"}`)
+	if status := f.Ping(ctx); !status.Healthy || status.StatusCode != http.StatusOK {
+		t.Fatalf("expected the solved page to be healthy, got %+v", status)
+	}
+}
+
+func TestFallbackStartHealthChecks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	getter := &stubGetter{name: "direct"}
+	f := NewFallback(getter, &disabledGetter{SourceGetter: &stubGetter{name: "broken"}})
+	f.StartHealthChecks(ctx, 10*time.Millisecond)
+
+	deadline := time.Now().Add(time.Second)
+	for getter.pings.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if getter.pings.Load() < 3 {
+		t.Fatalf("expected periodic pings, got %d", getter.pings.Load())
+	}
+	if _, err := f.Get(ctx, "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range f.Health() {
+		switch h.Name {
+		case "direct":
+			if !h.Status.Healthy || h.LastError != nil {
+				t.Fatalf("unexpected health %+v", h)
+			}
+		case "broken":
+			if h.Enabled || h.LastError == nil {
+				t.Fatalf("unexpected health %+v", h)
+			}
+		}
+	}
+	if status := f.Ping(ctx); !status.Healthy || !f.Enabled() {
+		t.Fatalf("expected fallback healthy and enabled with one getter up, got %+v", status)
+	}
+	if NewFallback(&disabledGetter{SourceGetter: &stubGetter{name: "broken"}}).Enabled() {
+		t.Fatal("expected a fallback with no enabled getter to be disabled")
+	}
+}
@@ -0,0 +1,122 @@
+package source_code
+
+import (
+	"context"
+	"github.com/Seann-Moser/cutil/logc"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+// Middleware wraps a SourceGetter to add behaviour around Get. Middlewares built
+// with WrapGet keep the wrapped getter's Name, Enabled and Ping.
+type Middleware func(SourceGetter) SourceGetter
+
+// GetFunc has the signature of SourceGetter.Get.
+type GetFunc func(ctx context.Context, endpoint string, options ...SourceOptions) (*SourceResponse, error)
+
+// Chain wraps getter in middlewares, the first one outermost so it sees the call first.
+func Chain(getter SourceGetter, middlewares ...Middleware) SourceGetter {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		getter = middlewares[i](getter)
+	}
+	return getter
+}
+
+// WrapGet builds a Middleware from a function that receives the next getter's Get.
+func WrapGet(wrap func(next SourceGetter, get GetFunc) GetFunc) Middleware {
+	return func(next SourceGetter) SourceGetter {
+		return &middlewareGetter{SourceGetter: next, get: wrap(next, next.Get)}
+	}
+}
+
+type middlewareGetter struct {
+	SourceGetter
+	get GetFunc
+}
+
+func (m *middlewareGetter) Get(ctx context.Context, endpoint string, options ...SourceOptions) (*SourceResponse, error) {
+	return m.get(ctx, endpoint, options...)
+}
+
+// Unwrap returns the wrapped getter so strategies can find its Cost and HandlesChallenge.
+func (m *middlewareGetter) Unwrap() SourceGetter {
+	return m.SourceGetter
+}
+
+// asGetter finds the first getter in the Unwrap chain that implements T.
+func asGetter[T any](getter SourceGetter) (T, bool) {
+	for getter != nil {
+		if t, ok := getter.(T); ok {
+			return t, true
+		}
+		unwrapper, ok := getter.(interface{ Unwrap() SourceGetter })
+		if !ok {
+			break
+		}
+		getter = unwrapper.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
+// Defaults puts options in front of the caller's, so the caller's still win.
+func Defaults(defaults SourceOptions) Middleware {
+	return WrapGet(func(next SourceGetter, get GetFunc) GetFunc {
+		return func(ctx context.Context, endpoint string, options ...SourceOptions) (*SourceResponse, error) {
+			return get(ctx, endpoint, append([]SourceOptions{defaults}, options...)...)
+		}
+	})
+}
+
+// Headers adds headers to every request unless the call sets them itself.
+func Headers(headers http.Header) Middleware {
+	return Defaults(SourceOptions{Headers: headers})
+}
+
+// Timeout bounds each Get, retries included, to d.
+func Timeout(d time.Duration) Middleware {
+	return WrapGet(func(next SourceGetter, get GetFunc) GetFunc {
+		return func(ctx context.Context, endpoint string, options ...SourceOptions) (*SourceResponse, error) {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			return get(ctx, endpoint, options...)
+		}
+	})
+}
+
+// Retry retries the whole Get with backOff, using the same rules as
+// SourceOptions.BackOff, so it also works around getters that ignore options.
+func Retry(backOff *BackOff) Middleware {
+	return WrapGet(func(next SourceGetter, get GetFunc) GetFunc {
+		return func(ctx context.Context, endpoint string, options ...SourceOptions) (*SourceResponse, error) {
+			return SourceOptions{BackOff: backOff}.fetch(ctx, func(ctx context.Context) (*SourceResponse, error) {
+				return get(ctx, endpoint, options...)
+			})
+		}
+	})
+}
+
+// Logging logs every Get through logc, at debug level on success and warn on failure.
+func Logging() Middleware {
+	return WrapGet(func(next SourceGetter, get GetFunc) GetFunc {
+		return func(ctx context.Context, endpoint string, options ...SourceOptions) (*SourceResponse, error) {
+			start := time.Now()
+			resp, err := get(ctx, endpoint, options...)
+			fields := []zap.Field{
+				zap.String("getter", next.Name()),
+				zap.String("url", endpoint),
+				zap.Duration("duration", time.Since(start)),
+			}
+			if resp != nil {
+				fields = append(fields, zap.Int("status_code", resp.StatusCode), zap.String("served_by", resp.Getter), zap.Bool("cached", resp.Cached))
+			}
+			if err != nil {
+				logc.Warn(ctx, "get failed", append(fields, zap.Error(err))...)
+			} else {
+				logc.Debug(ctx, "get", fields...)
+			}
+			return resp, err
+		}
+	})
+}
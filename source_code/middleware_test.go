@@ -0,0 +1,98 @@
+package source_code
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type flakyGetter struct {
+	stubGetter
+	failures int32
+	headers  http.Header
+}
+
+func (f *flakyGetter) Get(ctx context.Context, endpoint string, options ...SourceOptions) (*SourceResponse, error) {
+	f.headers = mergeSourceOptions(options...).Headers
+	resp, err := f.stubGetter.Get(ctx, endpoint, options...)
+	if f.gets.Load() <= f.failures {
+		resp.StatusCode = http.StatusServiceUnavailable
+	}
+	return resp, err
+}
+
+func TestChainOrderAndDelegation(t *testing.T) {
+	var order []string
+	trace := func(name string) Middleware {
+		return WrapGet(func(next SourceGetter, get GetFunc) GetFunc {
+			return func(ctx context.Context, endpoint string, options ...SourceOptions) (*SourceResponse, error) {
+				order = append(order, name)
+				return get(ctx, endpoint, options...)
+			}
+		})
+	}
+	inner := &stubGetter{name: "inner", cost: 3}
+	getter := Chain(inner, trace("first"), trace("second"), Logging())
+	if _, err := getter.Get(context.Background(), "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(order, ",") != "first,second" {
+		t.Fatalf("unexpected order %v", order)
+	}
+	if getter.Name() != "inner" || !getter.Enabled() || !getter.Ping(context.Background()).Healthy || inner.pings.Load() != 1 {
+		t.Fatalf("expected Name, Enabled and Ping to reach the inner getter")
+	}
+	if cost := (&CostAwareStrategy{}).cost(getter); cost != 3 {
+		t.Fatalf("expected the inner getter's cost, got %v", cost)
+	}
+}
+
+func TestRetryTimeoutAndHeaders(t *testing.T) {
+	ctx := context.Background()
+	flaky := &flakyGetter{stubGetter: stubGetter{name: "flaky"}, failures: 2}
+	getter := Chain(flaky, Retry(NewBackOff(5, time.Millisecond, time.Millisecond, time.Second)), Headers(http.Header{"X-Test": {"default"}}))
+	resp, err := getter.Get(ctx, "https://example.com", SourceOptions{Headers: http.Header{"X-Call": {"call"}}})
+	if err != nil || resp.StatusCode != http.StatusOK || flaky.gets.Load() != 3 {
+		t.Fatalf("expected success on the third attempt, got %v %v after %d", resp, err, flaky.gets.Load())
+	}
+	if flaky.headers.Get("X-Test") != "default" || flaky.headers.Get("X-Call") != "call" {
+		t.Fatalf("unexpected headers %v", flaky.headers)
+	}
+
+	slow := &stubGetter{name: "slow", delay: time.Second}
+	if _, err = Chain(slow, Timeout(10*time.Millisecond)).Get(ctx, "https://example.com"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+type solvingGetter struct {
+	stubGetter
+}
+
+func (s *solvingGetter) HandlesChallenge(info ChallengeInfo) bool {
+	return true
+}
+
+func TestWrappersUnwrap(t *testing.T) {
+	inner := &solvingGetter{stubGetter{name: "solver", cost: 5}}
+	var getter SourceGetter = NewValidated(inner, MinBodySize(1))
+	getter = NewRateLimited(getter, RateLimit{})
+	getter = NewRobots(getter, "wp-test")
+	getter = NewCache(getter, NewMemoryCache(1<<20), time.Minute)
+	pool, err := NewProxyPool(getter, ProxyRoundRobin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getter = Chain(NewBudgeted(pool, 0, 0), Logging())
+
+	if cost := (&CostAwareStrategy{}).cost(getter); cost != 5 {
+		t.Fatalf("expected the inner cost through every wrapper, got %v", cost)
+	}
+	ordered := preferChallengeHandlers([]SourceGetter{&stubGetter{name: "plain"}, getter}, ChallengeInfo{Vendor: VendorCloudflare})
+	if ordered[0] != getter {
+		t.Fatalf("expected the wrapped solver first, got %s", ordered[0].Name())
+	}
+}
@@ -0,0 +1,175 @@
+package source_code
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	bf "github.com/cenkalti/backoff/v4"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// StatusError marks a response whose status code is worth retrying (429 and 5xx).
+type StatusError struct {
+	StatusCode int
+}
+
+func (s *StatusError) Error() string {
+	return fmt.Sprintf("retryable status code %d", s.StatusCode)
+}
+
+func mergeSourceOptions(options ...SourceOptions) SourceOptions {
+	merged := SourceOptions{}
+	for _, o := range options {
+		if o.MaxDuration > 0 {
+			merged.MaxDuration = o.MaxDuration
+		}
+		if o.BackOff != nil {
+			merged.BackOff = o.BackOff
+		}
+		if o.Method != "" {
+			merged.Method = o.Method
+		}
+		if o.Body != nil {
+			merged.Body = o.Body
+		}
+		if o.UserAgent != "" {
+			merged.UserAgent = o.UserAgent
+		}
+		for key, values := range o.Headers {
+			if merged.Headers == nil {
+				merged.Headers = http.Header{}
+			}
+			merged.Headers[key] = values
+		}
+		merged.Cookies = append(merged.Cookies, o.Cookies...)
+		merged.Validators = append(merged.Validators, o.Validators...)
+		merged.IgnoreRobots = merged.IgnoreRobots || o.IgnoreRobots
+		if o.WaitSelector != "" {
+			merged.WaitSelector = o.WaitSelector
+		}
+		if o.SolveTimeout > 0 {
+			merged.SolveTimeout = o.SolveTimeout
+		}
+		if o.Proxy != "" {
+			merged.Proxy = o.Proxy
+		}
+		if o.MaxBodyBytes > 0 {
+			merged.MaxBodyBytes = o.MaxBodyBytes
+		}
+		merged.ZenRows = mergeZenRowsOptions(merged.ZenRows, o.ZenRows)
+	}
+	return merged
+}
+
+func (o SourceOptions) method() string {
+	if o.Method == "" {
+		return http.MethodGet
+	}
+	return o.Method
+}
+
+func (o SourceOptions) body() io.Reader {
+	if o.Body == nil {
+		return nil
+	}
+	return bytes.NewReader(o.Body)
+}
+
+// apply copies the headers, user agent and cookies from the options onto r.
+func (o SourceOptions) apply(r *http.Request) {
+	for key, values := range o.Headers {
+		for _, v := range values {
+			r.Header.Add(key, v)
+		}
+	}
+	if o.UserAgent != "" {
+		r.Header.Set("User-Agent", o.UserAgent)
+	}
+	for _, c := range o.Cookies {
+		r.AddCookie(c)
+	}
+}
+
+// run executes fetch bounded by MaxDuration and retried with BackOff when set,
+// then checks the response against the Validators.
+func (o SourceOptions) run(ctx context.Context, fetch func(ctx context.Context) (*SourceResponse, error)) (*SourceResponse, error) {
+	resp, err := o.fetch(ctx, fetch)
+	if err != nil {
+		return resp, err
+	}
+	// Getters that cannot stop reading early, such as FlareSolver, are checked here.
+	if o.MaxBodyBytes > 0 && resp != nil && int64(len(resp.Body)) > o.MaxBodyBytes {
+		return resp, fmt.Errorf("%w: %d bytes, limit %d", BodyTooLargeErr, len(resp.Body), o.MaxBodyBytes)
+	}
+	if len(o.Validators) == 0 {
+		return resp, err
+	}
+	return resp, validate(resp, o.Validators)
+}
+
+func (o SourceOptions) fetch(ctx context.Context, fetch func(ctx context.Context) (*SourceResponse, error)) (*SourceResponse, error) {
+	if o.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.MaxDuration)
+		defer cancel()
+	}
+	// A 429 or 5xx is returned as a StatusError with the response, once retries
+	// run out when BackOff is set, so Fallback moves on and Cache, Router and the
+	// health checks never take the error page for a success.
+	if o.BackOff == nil {
+		resp, err := fetch(ctx)
+		if err == nil && IsRetryable(resp, nil) {
+			err = &StatusError{StatusCode: resp.StatusCode}
+		}
+		return resp, err
+	}
+	var resp *SourceResponse
+	err := o.BackOff.Retry(ctx, func() error {
+		var err error
+		resp, err = fetch(ctx)
+		return classifyError(ctx, resp, err)
+	})
+	return resp, err
+}
+
+// IsRetryable reports whether a Get result is worth another attempt.
+func IsRetryable(resp *SourceResponse, err error) bool {
+	if err == nil {
+		return resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError)
+	}
+	if errors.Is(err, HasChallengeErr) || errors.Is(err, NotEnabledErr) || errors.Is(err, BodyTooLargeErr) ||
+		errors.Is(err, InvalidRequestErr) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) && urlErr.Op == "parse" {
+		return false
+	}
+	if resp != nil && resp.StatusCode >= http.StatusBadRequest && resp.StatusCode < http.StatusInternalServerError &&
+		resp.StatusCode != http.StatusTooManyRequests {
+		return false
+	}
+	return true
+}
+
+func classifyError(ctx context.Context, resp *SourceResponse, err error) error {
+	if !IsRetryable(resp, err) {
+		if err == nil {
+			return nil
+		}
+		return bf.Permanent(err)
+	}
+	if err == nil {
+		err = &StatusError{StatusCode: resp.StatusCode}
+	}
+	if ctx.Err() != nil {
+		return bf.Permanent(err)
+	}
+	return err
+}
@@ -0,0 +1,121 @@
+package source_code
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSourceOptionsRetry(t *testing.T) {
+	ctx := context.Background()
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	d := NewDirect(server.Client())
+	d.enabled.Store(true)
+	resp, err := d.Get(ctx, server.URL, SourceOptions{BackOff: NewBackOff(5, time.Millisecond, 5*time.Millisecond, time.Second)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || calls != 3 {
+		t.Fatalf("expected success after 3 calls, got status %d after %d calls", resp.StatusCode, calls)
+	}
+}
+
+func TestSourceOptionsRetryExhausted(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	d := NewDirect(server.Client())
+	d.enabled.Store(true)
+	backOff := SourceOptions{BackOff: NewBackOff(2, time.Millisecond, time.Millisecond, time.Second)}
+	resp, err := d.Get(ctx, server.URL, backOff)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected a StatusError, got %v", err)
+	}
+	if resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected the last response with the error, got %v", resp)
+	}
+
+	// Without BackOff the same 503 is classified the same way.
+	for _, o := range []SourceOptions{backOff, {}} {
+		f := NewFallback(&enabledGetter{d}, &stubGetter{name: "next"})
+		resp, err = f.Get(ctx, server.URL, o)
+		if err != nil || resp.Getter != "next" {
+			t.Fatalf("expected the fallback to move past the 503, got %v %v", resp, err)
+		}
+	}
+	if _, err = d.Get(ctx, server.URL); !errors.As(err, &statusErr) {
+		t.Fatalf("expected a StatusError without BackOff, got %v", err)
+	}
+}
+
+func TestSourceOptionsPermanent(t *testing.T) {
+	ctx := context.Background()
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		_, _ = w.Write([]byte(`<div id="challenge-error-title"></div>`))
+	}))
+	defer server.Close()
+
+	d := NewDirect(server.Client())
+	d.enabled.Store(true)
+	_, err := d.Get(ctx, server.URL, SourceOptions{BackOff: NewBackOff(5, time.Millisecond, 5*time.Millisecond, time.Second)})
+	if !errors.Is(err, HasChallengeErr) {
+		t.Fatalf("expected HasChallengeErr, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected challenge to not be retried, got %d calls", calls)
+	}
+}
+
+func TestSourceOptionsRequest(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie("session")
+		if r.Method != http.MethodPost || r.UserAgent() != "wp-test" || r.Header.Get("X-Test") != "1" || err != nil || c.Value != "abc" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.URL.Path == "/slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	d := NewDirect(server.Client())
+	d.enabled.Store(true)
+	options := []SourceOptions{
+		{Method: http.MethodPost, Body: []byte("payload"), UserAgent: "wp-test"},
+		{Headers: http.Header{"X-Test": []string{"1"}}, Cookies: []*http.Cookie{{Name: "session", Value: "abc"}}},
+	}
+	resp, err := d.Get(ctx, server.URL, options...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected options to be applied, got status %d", resp.StatusCode)
+	}
+
+	_, err = d.Get(ctx, server.URL+"/slow", append(options, SourceOptions{MaxDuration: 10 * time.Millisecond})...)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package source_code
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestProxy stands in for a forward proxy, answering every request itself.
+func newTestProxy(t *testing.T, status int, body string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !r.URL.IsAbs() {
+			t.Errorf("expected an absolute proxy request, got %s", r.URL)
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestProxyPoolRotationAndEviction(t *testing.T) {
+	ctx := context.Background()
+	good := newTestProxy(t, http.StatusOK, "good")
+	blocked := newTestProxy(t, http.StatusForbidden, "<title>Just a moment...</title>")
+
+	d := NewDirect(&http.Client{})
+	d.enabled.Store(true)
+	pool, err := NewProxyPool(d, ProxyRoundRobin, good.URL, blocked.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.MinRequests = 2
+	pool.MinScore = 0.5
+
+	var bodies []string
+	for i := 0; i < 6; i++ {
+		resp, err := pool.Get(ctx, "http://example.test/page")
+		if err != nil && !errors.Is(err, HasChallengeErr) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		bodies = append(bodies, string(resp.Body))
+	}
+	want := []string{"good", "<title>Just a moment...</title>", "good", "<title>Just a moment...</title>", "good", "good"}
+	for i := range want {
+		if bodies[i] != want[i] {
+			t.Fatalf("unexpected rotation %q", bodies)
+		}
+	}
+	stats := pool.Stats()
+	if stats[0].Evicted || !stats[1].Evicted || stats[1].Challenges != 2 || stats[0].Successes != 4 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestProxyPoolSticky(t *testing.T) {
+	ctx := context.Background()
+	a := newTestProxy(t, http.StatusOK, "a")
+	b := newTestProxy(t, http.StatusOK, "b")
+	path := filepath.Join(t.TempDir(), "proxies.txt")
+	if err := os.WriteFile(path, []byte("# pool\n"+a.URL+"\n\n"+b.URL+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	proxies, err := LoadProxies(path)
+	if err != nil || len(proxies) != 2 {
+		t.Fatalf("unexpected proxies %v: %v", proxies, err)
+	}
+
+	d := NewDirect(&http.Client{})
+	d.enabled.Store(true)
+	pool, err := NewProxyPool(d, ProxySticky, proxies...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"one.test", "two.test", "one.test", "two.test"} {
+		resp, err := pool.Get(ctx, "http://"+host+"/")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := "a"
+		if host == "two.test" {
+			want = "b"
+		}
+		if string(resp.Body) != want {
+			t.Fatalf("%s served by %s, expected %s", host, resp.Body, want)
+		}
+	}
+
+	if _, err = NewProxyPool(d, ProxySticky, "ftp://proxy.test"); err == nil {
+		t.Fatal("expected unsupported scheme to be rejected")
+	}
+}
+
+func TestProxyPoolRecordsProxyErrors(t *testing.T) {
+	ctx := context.Background()
+	good := newTestProxy(t, http.StatusOK, "too long for the limit")
+	auth := newTestProxy(t, http.StatusProxyAuthRequired, "")
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	d := NewDirect(&http.Client{})
+	d.enabled.Store(true)
+	pool, err := NewProxyPool(d, ProxyRoundRobin, good.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.MinRequests = 1
+	pool.MinScore = 0.5
+	if _, err = pool.Get(ctx, "http://example.test/page", SourceOptions{Validators: []Validator{MinBodySize(1 << 10)}}); !errors.Is(err, ValidationErr) {
+		t.Fatalf("expected ValidationErr, got %v", err)
+	}
+	if _, err = pool.Get(ctx, "http://example.test/page", SourceOptions{MaxBodyBytes: 4}); !errors.Is(err, BodyTooLargeErr) {
+		t.Fatalf("expected BodyTooLargeErr, got %v", err)
+	}
+	if stats := pool.Stats(); stats[0].Evicted || stats[0].Requests != 0 {
+		t.Fatalf("expected validation and body size errors not to count, got %+v", stats[0])
+	}
+
+	pool, err = NewProxyPool(d, ProxyRoundRobin, auth.URL, dead.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.MinRequests = 1
+	pool.MinScore = 0.5
+	for i := 0; i < 2; i++ {
+		_, _ = pool.Get(ctx, "http://example.test/page")
+	}
+	for _, stats := range pool.Stats() {
+		if !stats.Evicted || stats.Failures != 1 {
+			t.Fatalf("expected a 407 and a dead proxy to count against the proxy, got %+v", stats)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package source_code
+
+import (
+	"context"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var _ SourceGetter = &RateLimited{}
+
+// RateLimit is the politeness policy applied to every host independently.
+// Zero values disable the corresponding limit.
+type RateLimit struct {
+	RequestsPerSecond float64
+	Burst             int
+	MaxConcurrent     int
+	MinDelay          time.Duration
+	Jitter            time.Duration
+	// MaxSlowdown caps how far 429 and 503 responses stretch the host's delays.
+	MaxSlowdown float64
+}
+
+func RateLimitFlags(prefix string) *pflag.FlagSet {
+	fs := pflag.NewFlagSet(GetFlagWithPrefix("rate-limit", prefix), pflag.ExitOnError)
+	fs.Float64(GetFlagWithPrefix("rate-limit-rps", prefix), 1, "requests per second per host")
+	fs.Int(GetFlagWithPrefix("rate-limit-burst", prefix), 1, "requests per host allowed at once above the rate")
+	fs.Int(GetFlagWithPrefix("rate-limit-max-concurrent", prefix), 2, "concurrent requests per host")
+	fs.Duration(GetFlagWithPrefix("rate-limit-min-delay", prefix), 0, "minimum delay between requests to a host")
+	fs.Duration(GetFlagWithPrefix("rate-limit-jitter", prefix), 0, "random delay added to the minimum delay")
+	fs.Float64(GetFlagWithPrefix("rate-limit-max-slowdown", prefix), 16, "maximum slowdown factor after 429/503 responses")
+	return fs
+}
+
+func NewRateLimitWithFlags(prefix string) RateLimit {
+	return RateLimit{
+		RequestsPerSecond: viper.GetFloat64(GetFlagWithPrefix("rate-limit-rps", prefix)),
+		Burst:             viper.GetInt(GetFlagWithPrefix("rate-limit-burst", prefix)),
+		MaxConcurrent:     viper.GetInt(GetFlagWithPrefix("rate-limit-max-concurrent", prefix)),
+		MinDelay:          viper.GetDuration(GetFlagWithPrefix("rate-limit-min-delay", prefix)),
+		Jitter:            viper.GetDuration(GetFlagWithPrefix("rate-limit-jitter", prefix)),
+		MaxSlowdown:       viper.GetFloat64(GetFlagWithPrefix("rate-limit-max-slowdown", prefix)),
+	}
+}
+
+// RateLimited wraps a getter with a token bucket, a concurrency limit and a
+// minimum delay per host. 429 and 503 responses double the host's delays, up to
+// MaxSlowdown, and block it for the Retry-After duration; successes ease it back.
+type RateLimited struct {
+	getter SourceGetter
+	Limit  RateLimit
+
+	mu    sync.Mutex
+	hosts map[string]*hostLimiter
+}
+
+type hostLimiter struct {
+	mu           sync.Mutex
+	sem          chan struct{}
+	tokens       float64
+	refilled     time.Time
+	lastRequest  time.Time
+	blockedUntil time.Time
+	slowdown     float64
+	crawlDelay   time.Duration
+}
+
+func NewRateLimited(getter SourceGetter, limit RateLimit) *RateLimited {
+	return &RateLimited{
+		getter: getter,
+		Limit:  limit,
+		hosts:  make(map[string]*hostLimiter),
+	}
+}
+
+func NewRateLimitedWithFlags(getter SourceGetter, prefix string) *RateLimited {
+	return NewRateLimited(getter, NewRateLimitWithFlags(prefix))
+}
+
+func (r *RateLimited) host(endpoint string) *hostLimiter {
+	host := routeHost(endpoint)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.hosts == nil {
+		r.hosts = make(map[string]*hostLimiter)
+	}
+	h, found := r.hosts[host]
+	if !found {
+		h = &hostLimiter{
+			tokens:   float64(r.burst()),
+			refilled: time.Now(),
+			slowdown: 1,
+		}
+		if r.Limit.MaxConcurrent > 0 {
+			h.sem = make(chan struct{}, r.Limit.MaxConcurrent)
+		}
+		r.hosts[host] = h
+	}
+	return h
+}
+
+func (r *RateLimited) burst() int {
+	if r.Limit.Burst < 1 {
+		return 1
+	}
+	return r.Limit.Burst
+}
+
+// SetCrawlDelay raises the minimum delay for endpoint's host, e.g. from robots.txt Crawl-delay.
+func (r *RateLimited) SetCrawlDelay(endpoint string, delay time.Duration) {
+	h := r.host(endpoint)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.crawlDelay = delay
+}
+
+func (r *RateLimited) Get(ctx context.Context, endpoint string, options ...SourceOptions) (*SourceResponse, error) {
+	h := r.host(endpoint)
+	if h.sem != nil {
+		select {
+		case h.sem <- struct{}{}:
+			defer func() { <-h.sem }()
+		case <-ctx.Done():
+			return newSourceResponse(r.Name(), time.Now()).done(), ctx.Err()
+		}
+	}
+	if err := r.wait(ctx, h); err != nil {
+		return newSourceResponse(r.Name(), time.Now()).done(), err
+	}
+	resp, err := r.getter.Get(ctx, endpoint, options...)
+	r.observe(h, resp)
+	return resp, err
+}
+
+// wait blocks until the host has a token, its minimum delay has passed and any
+// Retry-After block has expired, then takes the token.
+func (r *RateLimited) wait(ctx context.Context, h *hostLimiter) error {
+	for {
+		h.mu.Lock()
+		now := time.Now()
+		delay := h.reserve(now, r.Limit, r.burst())
+		h.mu.Unlock()
+		if delay <= 0 {
+			return nil
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// reserve takes a token and returns 0 when a request may go now, otherwise how long to wait.
+func (h *hostLimiter) reserve(now time.Time, limit RateLimit, burst int) time.Duration {
+	var delay time.Duration
+	if now.Before(h.blockedUntil) {
+		delay = h.blockedUntil.Sub(now)
+	}
+	if !h.lastRequest.IsZero() {
+		minDelay := limit.MinDelay
+		if h.crawlDelay > minDelay {
+			minDelay = h.crawlDelay
+		}
+		minDelay = time.Duration(float64(minDelay) * h.slowdown)
+		if next := h.lastRequest.Add(minDelay); now.Before(next) && next.Sub(now) > delay {
+			delay = next.Sub(now)
+		}
+	}
+	if limit.RequestsPerSecond > 0 {
+		rate := limit.RequestsPerSecond / h.slowdown
+		h.tokens += now.Sub(h.refilled).Seconds() * rate
+		if h.tokens > float64(burst) {
+			h.tokens = float64(burst)
+		}
+		h.refilled = now
+		if h.tokens < 1 {
+			if wait := time.Duration((1 - h.tokens) / rate * float64(time.Second)); wait > delay {
+				delay = wait
+			}
+		}
+	}
+	if delay > 0 {
+		return delay
+	}
+	if limit.RequestsPerSecond > 0 {
+		h.tokens--
+	}
+	h.lastRequest = now
+	if limit.Jitter > 0 {
+		h.lastRequest = now.Add(time.Duration(rand.Int63n(int64(limit.Jitter))))
+	}
+	return 0
+}
+
+func (r *RateLimited) observe(h *hostLimiter, resp *SourceResponse) {
+	if resp == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		h.slowdown *= 2
+		if max := r.Limit.MaxSlowdown; max > 0 && h.slowdown > max {
+			h.slowdown = max
+		}
+		if retryAfter, ok := parseRetryAfter(resp.Headers, time.Now()); ok {
+			h.blockedUntil = time.Now().Add(retryAfter)
+		}
+	default:
+		if resp.StatusCode > 0 && resp.StatusCode < http.StatusBadRequest {
+			h.slowdown /= 2
+			if h.slowdown < 1 {
+				h.slowdown = 1
+			}
+		}
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an HTTP date.
+func parseRetryAfter(headers http.Header, now time.Time) (time.Duration, bool) {
+	value := headers.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return t.Sub(now), true
+	}
+	return 0, false
+}
+
+func (r *RateLimited) Ping(ctx context.Context) HealthStatus {
+	return r.getter.Ping(ctx)
+}
+
+func (r *RateLimited) Enabled() bool {
+	return r.getter.Enabled()
+}
+
+func (r *RateLimited) Name() string {
+	return r.getter.Name()
+}
+
+// Unwrap returns the rate limited getter.
+func (r *RateLimited) Unwrap() SourceGetter {
+	return r.getter
+}
@@ -0,0 +1,97 @@
+package source_code
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type statusGetter struct {
+	stubGetter
+	status    int
+	headers   http.Header
+	active    atomic.Int32
+	maxActive atomic.Int32
+	hold      time.Duration
+}
+
+func (s *statusGetter) Get(ctx context.Context, endpoint string, options ...SourceOptions) (*SourceResponse, error) {
+	active := s.active.Add(1)
+	defer s.active.Add(-1)
+	for {
+		current := s.maxActive.Load()
+		if active <= current || s.maxActive.CompareAndSwap(current, active) {
+			break
+		}
+	}
+	time.Sleep(s.hold)
+	return &SourceResponse{StatusCode: s.status, Headers: s.headers}, nil
+}
+
+func TestRateLimitedRate(t *testing.T) {
+	ctx := context.Background()
+	getter := &statusGetter{stubGetter: stubGetter{name: "status"}, status: http.StatusOK}
+	r := NewRateLimited(getter, RateLimit{RequestsPerSecond: 20, Burst: 1})
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if _, err := r.Get(ctx, "https://example.com/"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 180*time.Millisecond {
+		t.Fatalf("expected 5 requests at 20/s to take at least 200ms, took %s", elapsed)
+	}
+
+	start = time.Now()
+	if _, err := r.Get(ctx, "https://other.example.com/"); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 20*time.Millisecond {
+		t.Fatal("expected limits to be per host")
+	}
+}
+
+func TestRateLimitedConcurrency(t *testing.T) {
+	ctx := context.Background()
+	getter := &statusGetter{stubGetter: stubGetter{name: "status"}, status: http.StatusOK, hold: 20 * time.Millisecond}
+	r := NewRateLimited(getter, RateLimit{MaxConcurrent: 2})
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = r.Get(ctx, "https://example.com/")
+		}()
+	}
+	wg.Wait()
+	if getter.maxActive.Load() != 2 {
+		t.Fatalf("expected at most 2 concurrent requests, got %d", getter.maxActive.Load())
+	}
+}
+
+func TestRateLimitedRetryAfter(t *testing.T) {
+	ctx := context.Background()
+	getter := &statusGetter{
+		stubGetter: stubGetter{name: "status"},
+		status:     http.StatusTooManyRequests,
+		headers:    http.Header{"Retry-After": {"1"}},
+	}
+	r := NewRateLimited(getter, RateLimit{MaxSlowdown: 4})
+	if _, err := r.Get(ctx, "https://example.com/"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, err := r.Get(ctx, "https://example.com/"); err == nil {
+		t.Fatal("expected the host to be blocked for the Retry-After duration")
+	}
+	if h := r.host("https://example.com/"); h.slowdown != 2 {
+		t.Fatalf("expected slowdown of 2, got %v", h.slowdown)
+	}
+}
@@ -0,0 +1,149 @@
+package source_code
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+var _ SourceGetter = &Recorder{}
+
+// Recorder passes every Get through to the wrapped getter and keeps the request
+// and response as a HAR entry. Entries are written to the HAR file at Path by
+// Flush or Close, not on each Get, so call Close when the recording is done.
+type Recorder struct {
+	getter SourceGetter
+	Path   string
+
+	mu      sync.Mutex
+	har     *HAR
+	pending bool
+}
+
+// NewRecorder records into path, appending to the entries already there.
+func NewRecorder(getter SourceGetter, path string) (*Recorder, error) {
+	har, err := LoadHAR(path)
+	if errors.Is(err, os.ErrNotExist) {
+		har, err = NewHAR(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{
+		getter: getter,
+		Path:   path,
+		har:    har,
+	}, nil
+}
+
+func (r *Recorder) Get(ctx context.Context, endpoint string, options ...SourceOptions) (*SourceResponse, error) {
+	start := time.Now()
+	resp, err := r.getter.Get(ctx, endpoint, options...)
+	if resp == nil && err != nil {
+		return resp, err
+	}
+	r.record(start, endpoint, mergeSourceOptions(options...), resp, err)
+	return resp, err
+}
+
+func (r *Recorder) record(start time.Time, endpoint string, o SourceOptions, resp *SourceResponse, err error) {
+	request := http.Header{}
+	for key, values := range o.Headers {
+		request[key] = values
+	}
+	if o.UserAgent != "" {
+		request.Set("User-Agent", o.UserAgent)
+	}
+	entry := HAREntry{
+		StartedDateTime: start,
+		Time:            float64(time.Since(start)) / float64(time.Millisecond),
+		Request: HARRequest{
+			Method:      o.method(),
+			URL:         endpoint,
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     harCookies(o.Cookies),
+			Headers:     harHeaders(request),
+			QueryString: []HARNameValue{},
+			HeadersSize: -1,
+			BodySize:    len(o.Body),
+		},
+		Response: HARResponse{
+			Status:      resp.StatusCode,
+			StatusText:  http.StatusText(resp.StatusCode),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     harCookies(resp.Cookies),
+			Headers:     harHeaders(resp.Headers),
+			Content:     harContent(resp.Body, resp.ContentType()),
+			HeadersSize: -1,
+			BodySize:    len(resp.Body),
+			FinalURL:    resp.FinalURL,
+		},
+		Timings: HARTimings{Send: -1, Wait: float64(resp.Duration) / float64(time.Millisecond), Receive: -1},
+		Getter:  resp.Getter,
+	}
+	if u, parseErr := url.Parse(endpoint); parseErr == nil {
+		for key, values := range u.Query() {
+			for _, v := range values {
+				entry.Request.QueryString = append(entry.Request.QueryString, HARNameValue{Name: key, Value: v})
+			}
+		}
+	}
+	if o.Body != nil {
+		entry.Request.PostData = &HARPostData{MimeType: o.Headers.Get("Content-Type"), Text: string(o.Body)}
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.har.Log.Entries = append(r.har.Log.Entries, entry)
+	r.pending = true
+}
+
+// Flush writes the recording to Path if any entry was added since the last write.
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.pending {
+		return nil
+	}
+	if err := r.har.Save(r.Path); err != nil {
+		return fmt.Errorf("failed recording to %s: %w", r.Path, err)
+	}
+	r.pending = false
+	return nil
+}
+
+// Close writes any entries not yet flushed. The Recorder stays usable afterwards.
+func (r *Recorder) Close() error {
+	return r.Flush()
+}
+
+func (r *Recorder) Entries() []HAREntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]HAREntry(nil), r.har.Log.Entries...)
+}
+
+func (r *Recorder) Ping(ctx context.Context) HealthStatus {
+	return r.getter.Ping(ctx)
+}
+
+func (r *Recorder) Enabled() bool {
+	return r.getter.Enabled()
+}
+
+func (r *Recorder) Name() string {
+	return r.getter.Name()
+}
+
+// Unwrap returns the getter being recorded.
+func (r *Recorder) Unwrap() SourceGetter {
+	return r.getter
+}
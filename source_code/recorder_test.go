@@ -0,0 +1,64 @@
+package source_code
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecorderReplayer(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "recording.har")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/binary" {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = w.Write([]byte{0xff, 0xfe, 0x00})
+			return
+		}
+		_, _ = w.Write([]byte("<html>" + r.URL.Query().Get("q") + "</html>"))
+	}))
+	defer server.Close()
+
+	d := NewDirect(server.Client())
+	d.enabled.Store(true)
+	recorder, err := NewRecorder(d, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, endpoint := range []string{server.URL + "/search?q=first&ts=1", server.URL + "/binary"} {
+		if _, err = recorder.Get(ctx, endpoint); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(recorder.Entries()) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(recorder.Entries()))
+	}
+	if _, err = os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected entries to be buffered until Close, got %v", err)
+	}
+	if err = recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	replayer, err := NewReplayer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayer.Matcher = MatchIgnoreParams("ts")
+	resp, err := replayer.Get(ctx, server.URL+"/search?q=first&ts=2")
+	if err != nil || string(resp.Body) != "<html>first</html>" || resp.MediaType() != "text/html" || resp.Getter != d.Name() {
+		t.Fatalf("unexpected replay %v %v", resp, err)
+	}
+	resp, err = replayer.Get(ctx, server.URL+"/binary")
+	if err != nil || string(resp.Body) != string([]byte{0xff, 0xfe, 0x00}) {
+		t.Fatalf("unexpected binary replay %v %v", resp, err)
+	}
+	if _, err = replayer.Get(ctx, server.URL+"/missing"); !errors.Is(err, NoRecordingErr) {
+		t.Fatalf("expected NoRecordingErr, got %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package source_code
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var NoRecordingErr = errors.New("no recording")
+
+// URLMatcher reports whether a recorded URL answers a request for the requested one.
+type URLMatcher func(requested, recorded *url.URL) bool
+
+func MatchExactURL(requested, recorded *url.URL) bool {
+	return requested.String() == recorded.String()
+}
+
+func MatchIgnoreQuery(requested, recorded *url.URL) bool {
+	return requested.Scheme == recorded.Scheme && requested.Host == recorded.Host && requested.Path == recorded.Path
+}
+
+// MatchIgnoreParams matches URLs that are equal once the named query parameters,
+// such as cache busters or api keys, are removed.
+func MatchIgnoreParams(params ...string) URLMatcher {
+	strip := func(u *url.URL) string {
+		stripped := *u
+		query := stripped.Query()
+		for _, p := range params {
+			query.Del(p)
+		}
+		stripped.RawQuery = query.Encode()
+		return stripped.String()
+	}
+	return func(requested, recorded *url.URL) bool {
+		return strip(requested) == strip(recorded)
+	}
+}
+
+var _ SourceGetter = &Replayer{}
+var _ http.RoundTripper = &Replayer{}
+
+// Replayer serves responses from HAR files instead of the network. When several
+// entries match a request they are served in recorded order, repeating the last.
+// It is also an http.RoundTripper so http.Client based code can replay fixtures.
+type Replayer struct {
+	Matcher URLMatcher
+
+	mu      sync.Mutex
+	entries []HAREntry
+	served  map[string]int
+}
+
+func NewReplayer(paths ...string) (*Replayer, error) {
+	r := &Replayer{
+		Matcher: MatchExactURL,
+		served:  make(map[string]int),
+	}
+	for _, path := range paths {
+		har, err := LoadHAR(path)
+		if err != nil {
+			return nil, err
+		}
+		r.entries = append(r.entries, har.Log.Entries...)
+	}
+	return r, nil
+}
+
+func (r *Replayer) match(method, endpoint string) (*HAREntry, error) {
+	requested, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var matches []*HAREntry
+	for i := range r.entries {
+		e := &r.entries[i]
+		if !strings.EqualFold(e.Request.Method, method) {
+			continue
+		}
+		recorded, err := url.Parse(e.Request.URL)
+		if err != nil || !r.Matcher(requested, recorded) {
+			continue
+		}
+		matches = append(matches, e)
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%w for %s %s", NoRecordingErr, method, endpoint)
+	}
+	key := method + " " + endpoint
+	index := r.served[key]
+	if index >= len(matches) {
+		index = len(matches) - 1
+	}
+	r.served[key] = index + 1
+	return matches[index], nil
+}
+
+func (r *Replayer) Get(ctx context.Context, endpoint string, options ...SourceOptions) (*SourceResponse, error) {
+	o := mergeSourceOptions(options...)
+	sr := newSourceResponse(r.Name(), time.Now())
+	entry, err := r.match(o.method(), endpoint)
+	if err != nil {
+		sr.StatusCode = http.StatusNotFound
+		return sr.done(), err
+	}
+	body, err := entry.Response.Content.body()
+	if err != nil {
+		return sr.done(), err
+	}
+	sr.StatusCode = entry.Response.Status
+	sr.Headers = entry.Response.header()
+	sr.Cookies = entry.Response.cookies()
+	sr.Body = body
+	sr.FinalURL = entry.Response.FinalURL
+	if sr.FinalURL == "" {
+		sr.FinalURL = endpoint
+	}
+	if entry.Getter != "" {
+		sr.Getter = entry.Getter
+	}
+	if err = detectChallengeErr(sr.StatusCode, sr.Headers, body); err != nil {
+		return sr.done(), err
+	}
+	return sr.done(), validate(sr, o.Validators)
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+	entry, err := r.match(req.Method, req.URL.String())
+	if err != nil {
+		return nil, err
+	}
+	body, err := entry.Response.Content.body()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", entry.Response.Status, http.StatusText(entry.Response.Status)),
+		StatusCode:    entry.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        entry.Response.header(),
+		Body:          io.NopCloser(strings.NewReader(string(body))),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func (r *Replayer) Ping(ctx context.Context) HealthStatus {
+	return HealthStatus{Getter: r.Name(), Healthy: true, CheckedAt: time.Now()}
+}
+
+func (r *Replayer) Enabled() bool {
+	return true
+}
+
+func (r *Replayer) Name() string {
+	return "replayer"
+}
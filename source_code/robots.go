@@ -0,0 +1,315 @@
+package source_code
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrDisallowedByRobots = errors.New("disallowed by robots.txt")
+
+var DefaultRobotsTTL = 24 * time.Hour
+
+// DefaultRobotsErrorTTL is how long a failed robots.txt fetch is cached.
+var DefaultRobotsErrorTTL = 5 * time.Minute
+
+var _ SourceGetter = &Robots{}
+
+// Robots refuses Gets that the host's robots.txt disallows for UserAgent. robots.txt
+// is fetched through the wrapped getter and cached per host for TTL. A 4xx robots.txt
+// allows everything; a 5xx, challenged or failed fetch disallows everything unless
+// AllowOnError is set, and is only cached for ErrorTTL. A fetch cut short by the
+// caller's context is neither cached nor shared: callers waiting on it fetch again.
+//
+// Crawl-delay is only honoured through RateLimited. NewRobots sets it when getter
+// is, or wraps, a *RateLimited, which is how FromConfig builds the chain. Robots
+// built around a chain without one, or with the RateLimited outside it, ignore
+// Crawl-delay unless RateLimited is set by hand.
+type Robots struct {
+	getter       SourceGetter
+	UserAgent    string
+	TTL          time.Duration
+	ErrorTTL     time.Duration
+	AllowOnError bool
+	RateLimited  *RateLimited
+
+	mu    sync.Mutex
+	hosts map[string]*robotsEntry
+}
+
+type robotsEntry struct {
+	once    sync.Once
+	rules   *RobotsRules
+	expires time.Time
+	// cancelled is set when the fetch was cut short, the entry then has no rules.
+	cancelled bool
+}
+
+func NewRobots(getter SourceGetter, userAgent string) *Robots {
+	r := &Robots{
+		getter:    getter,
+		UserAgent: userAgent,
+		TTL:       DefaultRobotsTTL,
+		ErrorTTL:  DefaultRobotsErrorTTL,
+		hosts:     make(map[string]*robotsEntry),
+	}
+	r.RateLimited, _ = asGetter[*RateLimited](getter)
+	return r
+}
+
+func (r *Robots) Get(ctx context.Context, endpoint string, options ...SourceOptions) (*SourceResponse, error) {
+	o := mergeSourceOptions(options...)
+	if o.IgnoreRobots {
+		return r.getter.Get(ctx, endpoint, options...)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return newSourceResponse(r.Name(), time.Now()).done(), err
+	}
+	if u.Path != "/robots.txt" {
+		rules, err := r.rules(ctx, u)
+		if err != nil {
+			return newSourceResponse(r.Name(), time.Now()).done(), err
+		}
+		if !rules.Group(r.UserAgent).Allowed(u.RequestURI()) {
+			sr := newSourceResponse(r.Name(), time.Now())
+			sr.StatusCode = http.StatusForbidden
+			return sr.done(), fmt.Errorf("%s: %w", endpoint, ErrDisallowedByRobots)
+		}
+	}
+	return r.getter.Get(ctx, endpoint, options...)
+}
+
+// Allowed reports whether robots.txt lets UserAgent fetch endpoint.
+func (r *Robots) Allowed(ctx context.Context, endpoint string) (bool, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return false, err
+	}
+	rules, err := r.rules(ctx, u)
+	if err != nil {
+		return false, err
+	}
+	return rules.Group(r.UserAgent).Allowed(u.RequestURI()), nil
+}
+
+// rules returns the host's cached rules, fetching them once for every caller.
+// When the fetching caller's context is cancelled the others fetch again with
+// their own, so only ctx's own cancellation is returned as an error.
+func (r *Robots) rules(ctx context.Context, u *url.URL) (*RobotsRules, error) {
+	host := strings.ToLower(u.Host)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		e := r.entry(host)
+		e.once.Do(func() {
+			rules, ok := r.fetch(ctx, u)
+			r.mu.Lock()
+			if ctx.Err() != nil {
+				// a cancelled fetch says nothing about the host, fetch again next time.
+				e.cancelled = true
+				if r.hosts[host] == e {
+					delete(r.hosts, host)
+				}
+				r.mu.Unlock()
+				return
+			}
+			ttl := r.TTL
+			if !ok {
+				ttl = r.ErrorTTL
+			}
+			e.rules = rules
+			if ttl > 0 {
+				e.expires = time.Now().Add(ttl)
+			}
+			r.mu.Unlock()
+			if delay := rules.Group(r.UserAgent).CrawlDelay; delay > 0 && r.RateLimited != nil {
+				r.RateLimited.SetCrawlDelay(u.String(), delay)
+			}
+		})
+		if !e.cancelled {
+			return e.rules, nil
+		}
+	}
+}
+
+// entry returns the host's cache entry, replacing an expired one.
+func (r *Robots) entry(host string) *robotsEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.hosts == nil {
+		r.hosts = make(map[string]*robotsEntry)
+	}
+	e, found := r.hosts[host]
+	if !found || (!e.expires.IsZero() && time.Now().After(e.expires)) {
+		e = &robotsEntry{}
+		r.hosts[host] = e
+	}
+	return e
+}
+
+// fetch returns the host's rules and false when robots.txt could not be read.
+func (r *Robots) fetch(ctx context.Context, u *url.URL) (*RobotsRules, bool) {
+	robotsURL := url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/robots.txt"}
+	resp, err := r.getter.Get(ctx, robotsURL.String(), SourceOptions{IgnoreRobots: true})
+	switch {
+	case err == nil && resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices:
+		return ParseRobots(resp.Body), true
+	case err == nil && resp.StatusCode >= http.StatusBadRequest && resp.StatusCode < http.StatusInternalServerError:
+		return &RobotsRules{}, true
+	case r.AllowOnError:
+		return &RobotsRules{}, false
+	default:
+		return &RobotsRules{disallowAll: true}, false
+	}
+}
+
+func (r *Robots) Ping(ctx context.Context) HealthStatus {
+	return r.getter.Ping(ctx)
+}
+
+func (r *Robots) Enabled() bool {
+	return r.getter.Enabled()
+}
+
+func (r *Robots) Name() string {
+	return r.getter.Name()
+}
+
+// Unwrap returns the getter robots.txt is enforced for.
+func (r *Robots) Unwrap() SourceGetter {
+	return r.getter
+}
+
+// RobotsRules is a parsed robots.txt.
+type RobotsRules struct {
+	Groups      []RobotsGroup
+	disallowAll bool
+}
+
+type RobotsGroup struct {
+	UserAgents  []string
+	Rules       []RobotsRule
+	CrawlDelay  time.Duration
+	disallowAll bool
+}
+
+type RobotsRule struct {
+	Allow   bool
+	Pattern string
+	re      *regexp.Regexp
+}
+
+func ParseRobots(data []byte) *RobotsRules {
+	rules := &RobotsRules{}
+	var group *RobotsGroup
+	inAgents := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		switch key {
+		case "user-agent":
+			if !inAgents {
+				rules.Groups = append(rules.Groups, RobotsGroup{})
+				group = &rules.Groups[len(rules.Groups)-1]
+			}
+			group.UserAgents = append(group.UserAgents, strings.ToLower(value))
+			inAgents = true
+		case "allow", "disallow":
+			inAgents = false
+			if group == nil || (key == "disallow" && value == "") {
+				continue
+			}
+			group.Rules = append(group.Rules, RobotsRule{Allow: key == "allow", Pattern: value, re: robotsPattern(value)})
+		case "crawl-delay":
+			inAgents = false
+			if group == nil {
+				continue
+			}
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+				group.CrawlDelay = time.Duration(seconds * float64(time.Second))
+			}
+		}
+	}
+	return rules
+}
+
+// robotsPattern turns a robots.txt path into a regexp where * matches anything
+// and a trailing $ anchors the end of the path.
+func robotsPattern(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	expr := "^" + strings.Join(parts, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
+
+// Group returns the group for the most specific user agent matching userAgent,
+// falling back to the * group.
+func (r *RobotsRules) Group(userAgent string) RobotsGroup {
+	if r.disallowAll {
+		return RobotsGroup{disallowAll: true}
+	}
+	userAgent = strings.ToLower(userAgent)
+	var best *RobotsGroup
+	bestLen := -1
+	for i := range r.Groups {
+		g := &r.Groups[i]
+		for _, agent := range g.UserAgents {
+			switch {
+			case agent == "*" && bestLen < 0:
+				best, bestLen = g, 0
+			case agent != "*" && agent != "" && strings.Contains(userAgent, agent) && len(agent) > bestLen:
+				best, bestLen = g, len(agent)
+			}
+		}
+	}
+	if best == nil {
+		return RobotsGroup{}
+	}
+	return *best
+}
+
+// Allowed applies the longest matching rule to path, Allow winning ties.
+func (g RobotsGroup) Allowed(path string) bool {
+	if g.disallowAll {
+		return false
+	}
+	allowed := true
+	longest := -1
+	for _, rule := range g.Rules {
+		if !rule.re.MatchString(path) {
+			continue
+		}
+		if len(rule.Pattern) > longest || (len(rule.Pattern) == longest && rule.Allow) {
+			allowed = rule.Allow
+			longest = len(rule.Pattern)
+		}
+	}
+	return allowed
+}
@@ -0,0 +1,173 @@
+package source_code
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testRobots = `
+# comment
+User-agent: *
+Disallow: /private/
+Allow: /private/public$
+Disallow: /*.pdf$
+Crawl-delay: 2
+
+User-agent: wp-bot
+User-agent: other-bot
+Disallow: /
+Allow: /allowed/
+Crawl-delay: 0.5
+`
+
+func TestParseRobots(t *testing.T) {
+	rules := ParseRobots([]byte(testRobots))
+	tests := []struct {
+		userAgent string
+		path      string
+		allowed   bool
+	}{
+		{userAgent: "Mozilla/5.0", path: "/", allowed: true},
+		{userAgent: "Mozilla/5.0", path: "/private/page", allowed: false},
+		{userAgent: "Mozilla/5.0", path: "/private/public", allowed: true},
+		{userAgent: "Mozilla/5.0", path: "/private/public/more", allowed: false},
+		{userAgent: "Mozilla/5.0", path: "/files/report.pdf", allowed: false},
+		{userAgent: "Mozilla/5.0", path: "/files/report.pdf?x=1", allowed: true},
+		{userAgent: "WP-Bot/1.0", path: "/", allowed: false},
+		{userAgent: "WP-Bot/1.0", path: "/allowed/page", allowed: true},
+	}
+	for _, tt := range tests {
+		if allowed := rules.Group(tt.userAgent).Allowed(tt.path); allowed != tt.allowed {
+			t.Errorf("%s %s: expected allowed %v, got %v", tt.userAgent, tt.path, tt.allowed, allowed)
+		}
+	}
+	if delay := rules.Group("wp-bot").CrawlDelay; delay != 500*time.Millisecond {
+		t.Fatalf("expected crawl delay of 500ms, got %s", delay)
+	}
+}
+
+func TestRobots(t *testing.T) {
+	ctx := context.Background()
+	var robotsFetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			robotsFetches.Add(1)
+			_, _ = w.Write([]byte(testRobots))
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	d := NewDirect(server.Client())
+	d.enabled.Store(true)
+	limited := NewRateLimited(d, RateLimit{})
+	robots := NewRobots(Chain(limited, Logging()), "wp-bot")
+
+	if _, err := robots.Get(ctx, server.URL+"/allowed/page"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := robots.Get(ctx, server.URL+"/blocked")
+	if !errors.Is(err, ErrDisallowedByRobots) {
+		t.Fatalf("expected ErrDisallowedByRobots, got %v", err)
+	}
+	if _, err = robots.Get(ctx, server.URL+"/blocked", SourceOptions{IgnoreRobots: true}); err != nil {
+		t.Fatalf("expected IgnoreRobots to bypass robots.txt, got %v", err)
+	}
+	if robotsFetches.Load() != 1 {
+		t.Fatalf("expected robots.txt to be cached, got %d fetches", robotsFetches.Load())
+	}
+	if h := limited.host(server.URL); h.crawlDelay != 500*time.Millisecond {
+		t.Fatalf("expected crawl delay to reach the rate limiter, got %s", h.crawlDelay)
+	}
+}
+
+func TestRobotsUnavailable(t *testing.T) {
+	ctx := context.Background()
+	var status atomic.Int32
+	status.Store(http.StatusNotFound)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.WriteHeader(int(status.Load()))
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	d := NewDirect(server.Client())
+	d.enabled.Store(true)
+	if _, err := NewRobots(d, "wp-bot").Get(ctx, server.URL+"/page"); err != nil {
+		t.Fatalf("expected missing robots.txt to allow everything, got %v", err)
+	}
+	status.Store(http.StatusInternalServerError)
+	robots := NewRobots(d, "wp-bot")
+	robots.ErrorTTL = 20 * time.Millisecond
+	if _, err := robots.Get(ctx, server.URL+"/page"); !errors.Is(err, ErrDisallowedByRobots) {
+		t.Fatalf("expected failing robots.txt to disallow everything, got %v", err)
+	}
+	status.Store(http.StatusNotFound)
+	time.Sleep(40 * time.Millisecond)
+	if _, err := robots.Get(ctx, server.URL+"/page"); err != nil {
+		t.Fatalf("expected the failure to expire after ErrorTTL, got %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	robots = NewRobots(d, "wp-bot")
+	_, _ = robots.Get(cancelled, server.URL+"/page")
+	if _, err := robots.Get(ctx, server.URL+"/page"); err != nil {
+		t.Fatalf("expected a cancelled fetch not to be cached, got %v", err)
+	}
+}
+
+func TestRobotsCancelledFetchNotShared(t *testing.T) {
+	ctx := context.Background()
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			if fetches.Add(1) == 1 {
+				// Hang until the first caller gives up.
+				<-r.Context().Done()
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	d := NewDirect(server.Client())
+	d.enabled.Store(true)
+	robots := NewRobots(d, "wp-bot")
+	first, cancel := context.WithCancel(ctx)
+	errs := make(chan error, 2)
+	go func() {
+		_, err := robots.Get(first, server.URL+"/page")
+		errs <- err
+	}()
+	for fetches.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	go func() {
+		_, err := robots.Get(ctx, server.URL+"/other")
+		errs <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	for i := 0; i < 2; i++ {
+		if err := <-errs; errors.Is(err, ErrDisallowedByRobots) || (err != nil && !errors.Is(err, context.Canceled)) {
+			t.Fatalf("expected the waiter to fetch again instead of sharing the cancelled fetch, got %v", err)
+		}
+	}
+	if fetches.Load() != 2 {
+		t.Fatalf("expected robots.txt fetched again after the cancellation, got %d fetches", fetches.Load())
+	}
+}
@@ -0,0 +1,243 @@
+package source_code
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Seann-Moser/cutil/logc"
+	"go.uber.org/zap"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var DefaultRouteTTL = 24 * time.Hour
+
+// DefaultRouteSaveDelay is how long Router batches changes before saving.
+var DefaultRouteSaveDelay = time.Second
+
+// Route remembers which getter last served a host.
+type Route struct {
+	Getter  string    `json:"getter"`
+	Expires time.Time `json:"expires"`
+}
+
+// Router is a per-host table of the getter that last succeeded. Fallback tries the
+// routed getter first so protected hosts skip getters that are known to be blocked.
+// When Path is set the table is written there as JSON shortly after a route
+// changes, and at most every tenth of TTL when a route is only extended, so the
+// saved expiry never lags far behind. Saving happens off the Get path; call Close
+// before exiting to write any pending change.
+type Router struct {
+	TTL  time.Duration
+	Path string
+	// SaveDelay is how long changes are batched before one save, defaulting
+	// to DefaultRouteSaveDelay.
+	SaveDelay time.Duration
+
+	mu        sync.Mutex
+	routes    map[string]Route
+	saved     time.Time
+	saveTimer *time.Timer
+	saveErr   error
+}
+
+func NewRouter(ttl time.Duration) *Router {
+	return &Router{
+		TTL:    ttl,
+		routes: make(map[string]Route),
+	}
+}
+
+// NewRouterFromFile creates a Router persisted at path, loading any routes already saved there.
+func NewRouterFromFile(path string, ttl time.Duration) (*Router, error) {
+	r := NewRouter(ttl)
+	r.Path = path
+	if err := r.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return r, nil
+}
+
+func routeHost(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// Lookup returns the getter name routed for the endpoint's host, if it has not expired.
+func (r *Router) Lookup(endpoint string) (string, bool) {
+	host := routeHost(endpoint)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	route, found := r.routes[host]
+	if !found {
+		return "", false
+	}
+	if !route.Expires.IsZero() && time.Now().After(route.Expires) {
+		delete(r.routes, host)
+		return "", false
+	}
+	return route.Getter, true
+}
+
+// Learn records getter as the one to try first for the endpoint's host.
+func (r *Router) Learn(ctx context.Context, endpoint, getter string) {
+	host := routeHost(endpoint)
+	if host == "" {
+		return
+	}
+	r.mu.Lock()
+	previous, found := r.routes[host]
+	route := Route{Getter: getter}
+	if r.TTL > 0 {
+		route.Expires = time.Now().Add(r.TTL)
+	}
+	if r.routes == nil {
+		r.routes = make(map[string]Route)
+	}
+	r.routes[host] = route
+	extended := r.TTL > 0 && time.Since(r.saved) >= r.TTL/10
+	if !found || previous.Getter != getter || extended {
+		r.scheduleSave()
+	}
+	r.mu.Unlock()
+}
+
+func (r *Router) Forget(ctx context.Context, endpoint string) {
+	host := routeHost(endpoint)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, found := r.routes[host]; found {
+		delete(r.routes, host)
+		r.scheduleSave()
+	}
+}
+
+// Routes returns a copy of the unexpired routing table keyed by host.
+func (r *Router) Routes() map[string]Route {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	routes := make(map[string]Route, len(r.routes))
+	now := time.Now()
+	for host, route := range r.routes {
+		if !route.Expires.IsZero() && now.After(route.Expires) {
+			continue
+		}
+		routes[host] = route
+	}
+	return routes
+}
+
+// Order moves the getter routed for endpoint to the front, keeping the rest in order.
+func (r *Router) Order(endpoint string, getters []SourceGetter) []SourceGetter {
+	name, found := r.Lookup(endpoint)
+	if !found {
+		return getters
+	}
+	ordered := make([]SourceGetter, 0, len(getters))
+	for _, getter := range getters {
+		if getter.Name() == name {
+			ordered = append(ordered, getter)
+		}
+	}
+	if len(ordered) == 0 {
+		return getters
+	}
+	for _, getter := range getters {
+		if getter.Name() != name {
+			ordered = append(ordered, getter)
+		}
+	}
+	return ordered
+}
+
+// scheduleSave starts the SaveDelay timer unless one is already pending. r.mu must be held.
+func (r *Router) scheduleSave() {
+	if r.Path == "" || r.saveTimer != nil {
+		return
+	}
+	delay := r.SaveDelay
+	if delay <= 0 {
+		delay = DefaultRouteSaveDelay
+	}
+	r.saveTimer = time.AfterFunc(delay, r.save)
+}
+
+// save writes the batched changes, logging a failure and keeping it for Err.
+func (r *Router) save() {
+	r.mu.Lock()
+	r.saveTimer = nil
+	r.mu.Unlock()
+	err := r.Save()
+	if err != nil {
+		logc.Warn(context.Background(), "failed saving routes", zap.String("path", r.Path), zap.Error(err))
+	}
+	r.mu.Lock()
+	r.saveErr = err
+	r.mu.Unlock()
+}
+
+// Flush saves any change still waiting for SaveDelay and returns the result.
+func (r *Router) Flush() error {
+	r.mu.Lock()
+	pending := r.saveTimer != nil && r.saveTimer.Stop()
+	r.saveTimer = nil
+	r.mu.Unlock()
+	if !pending {
+		return r.Err()
+	}
+	err := r.Save()
+	r.mu.Lock()
+	r.saveErr = err
+	r.mu.Unlock()
+	return err
+}
+
+// Close flushes pending changes. The Router stays usable afterwards.
+func (r *Router) Close() error {
+	return r.Flush()
+}
+
+// Err returns the error from the last save, if it failed.
+func (r *Router) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.saveErr
+}
+
+// Save writes the unexpired routes to Path, replacing the file atomically.
+func (r *Router) Save() error {
+	start := time.Now()
+	b, err := json.MarshalIndent(r.Routes(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = writeFileAtomic(r.Path, b); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.saved = start
+	r.mu.Unlock()
+	return nil
+}
+
+// Load replaces the routing table with the routes saved at Path.
+func (r *Router) Load() error {
+	b, err := os.ReadFile(r.Path)
+	if err != nil {
+		return err
+	}
+	routes := map[string]Route{}
+	if err = json.Unmarshal(b, &routes); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes = routes
+	return nil
+}
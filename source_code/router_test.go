@@ -0,0 +1,146 @@
+package source_code
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFallbackRouter(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "routes.json")
+	router, err := NewRouterFromFile(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	direct := &stubGetter{name: "direct", err: HasChallengeErr}
+	flare := &stubGetter{name: "flaresolver"}
+	f := NewFallback(direct, flare)
+	f.Router = router
+
+	for i := 0; i < 3; i++ {
+		resp, err := f.Get(ctx, "https://Protected.example.com/page")
+		if err != nil || resp.Getter != "flaresolver" {
+			t.Fatalf("expected flaresolver, got %v %v", resp, err)
+		}
+	}
+	if direct.gets.Load() != 1 {
+		t.Fatalf("expected direct to be skipped once routed, got %d gets", direct.gets.Load())
+	}
+
+	if _, err = f.Get(ctx, "https://other.example.com/"); err != nil {
+		t.Fatal(err)
+	}
+	if direct.gets.Load() != 2 {
+		t.Fatal("expected routes to be per host")
+	}
+	if err = router.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := NewRouterFromFile(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, found := loaded.Lookup("https://protected.example.com/other"); !found || name != "flaresolver" {
+		t.Fatalf("expected persisted route, got %q %v", name, found)
+	}
+}
+
+func TestRouterPersistsExtendedRoutes(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "routes.json")
+	router, err := NewRouterFromFile(path, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.Learn(ctx, "https://example.com", "direct")
+	first := router.Routes()["example.com"].Expires
+	time.Sleep(20 * time.Millisecond)
+	router.Learn(ctx, "https://example.com", "direct")
+	if err = router.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := NewRouterFromFile(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved := loaded.Routes()["example.com"].Expires; !saved.After(first) {
+		t.Fatalf("expected the extended expiry saved, got %s after %s", saved, first)
+	}
+}
+
+func TestRouterSavesInBackground(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "routes.json")
+	router, err := NewRouterFromFile(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.SaveDelay = 20 * time.Millisecond
+	router.Learn(ctx, "https://a.example.com", "direct")
+	router.Learn(ctx, "https://b.example.com", "flaresolver")
+	if _, err = os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected Learn to not save inline, got %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	loaded, err := NewRouterFromFile(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if routes := loaded.Routes(); len(routes) != 2 {
+		t.Fatalf("expected both routes saved together, got %+v", routes)
+	}
+}
+
+func TestRouterExpiry(t *testing.T) {
+	ctx := context.Background()
+	router := NewRouter(10 * time.Millisecond)
+	router.Learn(ctx, "https://example.com", "direct")
+	if _, found := router.Lookup("https://example.com"); !found {
+		t.Fatal("expected route")
+	}
+	time.Sleep(15 * time.Millisecond)
+	if _, found := router.Lookup("https://example.com"); found {
+		t.Fatal("expected route to expire")
+	}
+}
+
+// lateGetter succeeds after delay even when its context is cancelled, like a
+// getter that only checks the context between requests.
+type lateGetter struct {
+	stubGetter
+}
+
+func (l *lateGetter) Get(ctx context.Context, endpoint string, options ...SourceOptions) (*SourceResponse, error) {
+	l.gets.Add(1)
+	time.Sleep(l.delay)
+	return &SourceResponse{Getter: l.name, StatusCode: http.StatusOK}, nil
+}
+
+func TestFallbackRaceLearnsWinner(t *testing.T) {
+	ctx := context.Background()
+	router := NewRouter(time.Hour)
+	fast := &stubGetter{name: "fast", delay: 10 * time.Millisecond}
+	late := &lateGetter{stubGetter{name: "late", delay: 50 * time.Millisecond}}
+	f := NewFallback(fast, late)
+	f.Strategy = &RaceStrategy{}
+	f.Router = router
+
+	resp, err := f.Get(ctx, "https://example.com")
+	if err != nil || resp.Getter != "fast" {
+		t.Fatalf("expected fast getter to win, got %v %v", resp, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if name, _ := router.Lookup("https://example.com"); name != "fast" {
+		t.Fatalf("expected the winner routed, got %q", name)
+	}
+	if late.gets.Load() != 1 {
+		t.Fatal("expected the late getter to have run")
+	}
+}
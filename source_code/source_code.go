@@ -3,20 +3,112 @@ package source_code
 import (
 	"context"
 	"errors"
+	"mime"
+	"net/http"
 	"time"
 )
 
 var PingURL = "https://www.google.com/"
 var HasChallengeErr = errors.New("has challenge")
+var NotEnabledErr = errors.New("not enabled")
 
+// InvalidRequestErr wraps errors building a request from the caller's
+// options; retrying cannot fix them.
+var InvalidRequestErr = errors.New("invalid request")
+
+// SourceOptions tune a single Get call. When several are passed they are merged
+// in order, later non-zero values winning. Headers are merged key by key, a later
+// value replacing an earlier one for the same key; cookies and validators accumulate.
 type SourceOptions struct {
 	MaxDuration time.Duration
 	BackOff     *BackOff
+	Method      string
+	Headers     http.Header
+	Body        []byte
+	UserAgent   string
+	Cookies     []*http.Cookie
+	// Validators run on every successful response; a failure is treated like a challenge.
+	Validators []Validator
+	// IgnoreRobots skips robots.txt checks for this call when the getter is wrapped by Robots.
+	IgnoreRobots bool
+	// WaitSelector makes rendering getters such as Browser wait for a CSS selector.
+	WaitSelector string
+	// SolveTimeout caps how long a solving getter such as FlareSolver may spend on
+	// the challenge; zero keeps the getter's default.
+	SolveTimeout time.Duration
+	// Proxy routes the request through a proxy URL (with optional user:password).
+	// Direct and FlareSolver honour it; ProxyPool sets it from its rotation.
+	Proxy string
+	// MaxBodyBytes fails the call with BodyTooLargeErr once the body passes it.
+	MaxBodyBytes int64
+	// ZenRows overrides ZenRows' default API parameters for this call.
+	ZenRows *ZenRowsOptions
+}
+
+// SourceResponse is what every SourceGetter returns from Get. It can be non-nil
+// alongside an error so callers still see the status code and which getter failed.
+type SourceResponse struct {
+	Body       []byte
+	StatusCode int
+	Headers    http.Header
+	FinalURL   string
+	Cookies    []*http.Cookie
+	Getter     string
+	StartedAt  time.Time
+	Duration   time.Duration
+	// Cached is set when the response was served from a Cache without calling Getter.
+	Cached bool
+	// Screenshot is a PNG of the rendered page from getters that support it.
+	Screenshot []byte
+	// Cost is what the provider billed for the request, when it reports it.
+	Cost float64
+}
+
+func (s *SourceResponse) ContentType() string {
+	if s == nil || s.Headers == nil {
+		return ""
+	}
+	return s.Headers.Get("Content-Type")
+}
+
+// MediaType returns the content type without parameters such as charset.
+func (s *SourceResponse) MediaType() string {
+	ct := s.ContentType()
+	if ct == "" {
+		return ""
+	}
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return ct
+	}
+	return mt
 }
 
 type SourceGetter interface {
-	Get(ctx context.Context, endpoint string, options ...SourceOptions) ([]byte, int, error)
-	Ping(ctx context.Context) bool
+	Get(ctx context.Context, endpoint string, options ...SourceOptions) (*SourceResponse, error)
+	Ping(ctx context.Context) HealthStatus
 	Enabled() bool
 	Name() string
 }
+
+func newSourceResponse(getter string, start time.Time) *SourceResponse {
+	return &SourceResponse{
+		Getter:    getter,
+		StartedAt: start,
+	}
+}
+
+func (s *SourceResponse) fromHTTP(resp *http.Response) *SourceResponse {
+	s.StatusCode = resp.StatusCode
+	s.Headers = resp.Header
+	s.Cookies = resp.Cookies()
+	if resp.Request != nil && resp.Request.URL != nil {
+		s.FinalURL = resp.Request.URL.String()
+	}
+	return s
+}
+
+func (s *SourceResponse) done() *SourceResponse {
+	s.Duration = time.Since(s.StartedAt)
+	return s
+}
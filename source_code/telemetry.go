@@ -0,0 +1,254 @@
+package source_code
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// TracerName is the instrumentation name spans from this package are created under.
+const TracerName = "github.com/Seann-Moser/wp/source_code"
+
+// Metrics holds the Prometheus collectors for fetches. Attach it to getters with
+// Middleware, to a Fallback through its Metrics field and to budgets with
+// AddBudget. A nil *Metrics records nothing.
+type Metrics struct {
+	Requests    *prometheus.CounterVec
+	Challenges  *prometheus.CounterVec
+	Escalations *prometheus.CounterVec
+	Bytes       *prometheus.CounterVec
+	Latency     *prometheus.HistogramVec
+
+	budgets *budgetCollector
+}
+
+// NewMetrics creates the collectors and registers them with reg, or with
+// prometheus.DefaultRegisterer when reg is nil.
+func NewMetrics(reg prometheus.Registerer) (*Metrics, error) {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	m := &Metrics{
+		Requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wp_requests_total",
+			Help: "Get calls per getter and status code, 0 when no response was received.",
+		}, []string{"getter", "code", "outcome"}),
+		Challenges: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wp_challenges_total",
+			Help: "Anti-bot challenges detected per getter and vendor.",
+		}, []string{"getter", "vendor"}),
+		Escalations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wp_fallback_escalations_total",
+			Help: "Times a Fallback moved past a getter, by the getter and why.",
+		}, []string{"getter", "reason"}),
+		Bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wp_response_bytes_total",
+			Help: "Response body bytes fetched per getter.",
+		}, []string{"getter"}),
+		Latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "wp_request_duration_seconds",
+			Help:    "Get latency per getter.",
+			Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+		}, []string{"getter"}),
+		budgets: newBudgetCollector(),
+	}
+	for _, c := range []prometheus.Collector{m.Requests, m.Challenges, m.Escalations, m.Bytes, m.Latency, m.budgets} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Middleware records every Get made through the wrapped getter, labelled with
+// the wrapped getter's Name.
+func (m *Metrics) Middleware() Middleware {
+	if m == nil {
+		return func(next SourceGetter) SourceGetter { return next }
+	}
+	return WrapGet(func(next SourceGetter, get GetFunc) GetFunc {
+		return func(ctx context.Context, endpoint string, options ...SourceOptions) (*SourceResponse, error) {
+			start := time.Now()
+			resp, err := get(ctx, endpoint, options...)
+			m.observe(next.Name(), resp, err, time.Since(start))
+			return resp, err
+		}
+	})
+}
+
+func (m *Metrics) observe(name string, resp *SourceResponse, err error, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+		m.Bytes.WithLabelValues(name).Add(float64(len(resp.Body)))
+	}
+	m.Requests.WithLabelValues(name, strconv.Itoa(status), outcome(err)).Inc()
+	m.Latency.WithLabelValues(name).Observe(duration.Seconds())
+	var challengeErr *ChallengeError
+	if errors.As(err, &challengeErr) {
+		m.Challenges.WithLabelValues(name, string(challengeErr.Info.Vendor)).Inc()
+	} else if errors.Is(err, HasChallengeErr) {
+		m.Challenges.WithLabelValues(name, string(VendorGeneric)).Inc()
+	}
+}
+
+// escalation counts a Fallback giving up on a getter.
+func (m *Metrics) escalation(attempt FallbackAttempt) {
+	if m == nil {
+		return
+	}
+	reason := outcome(attempt.Err)
+	if attempt.Skipped {
+		reason = "skipped"
+	}
+	m.Escalations.WithLabelValues(attempt.Getter, reason).Inc()
+}
+
+// AddBudget exports b's spend and limits as gauges labelled with its Name.
+func (m *Metrics) AddBudget(b *Budgeted) {
+	if m == nil {
+		return
+	}
+	m.budgets.add(b)
+}
+
+// budgetCollector reads every added Budgeted's Spend at scrape time.
+type budgetCollector struct {
+	spent    *prometheus.Desc
+	limit    *prometheus.Desc
+	requests *prometheus.Desc
+	credits  *prometheus.Desc
+
+	mu      sync.Mutex
+	budgets []*Budgeted
+}
+
+func newBudgetCollector() *budgetCollector {
+	return &budgetCollector{
+		spent:    prometheus.NewDesc("wp_budget_spent_credits", "Credits spent in the current period.", []string{"budget", "period"}, nil),
+		limit:    prometheus.NewDesc("wp_budget_limit_credits", "Credits allowed per period, 0 when uncapped.", []string{"budget", "period"}, nil),
+		requests: prometheus.NewDesc("wp_budget_getter_requests", "Requests made by each getter this month.", []string{"budget", "getter"}, nil),
+		credits:  prometheus.NewDesc("wp_budget_getter_credits", "Credits spent by each getter this month.", []string{"budget", "getter"}, nil),
+	}
+}
+
+func (c *budgetCollector) add(b *Budgeted) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.budgets = append(c.budgets, b)
+}
+
+func (c *budgetCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.spent
+	ch <- c.limit
+	ch <- c.requests
+	ch <- c.credits
+}
+
+func (c *budgetCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	budgets := append([]*Budgeted(nil), c.budgets...)
+	c.mu.Unlock()
+	for _, b := range budgets {
+		name := b.Name()
+		spend := b.Spend()
+		ch <- prometheus.MustNewConstMetric(c.spent, prometheus.GaugeValue, spend.Daily, name, "daily")
+		ch <- prometheus.MustNewConstMetric(c.spent, prometheus.GaugeValue, spend.Monthly, name, "monthly")
+		ch <- prometheus.MustNewConstMetric(c.limit, prometheus.GaugeValue, b.Daily, name, "daily")
+		ch <- prometheus.MustNewConstMetric(c.limit, prometheus.GaugeValue, b.Monthly, name, "monthly")
+		for getter, s := range spend.Getters {
+			ch <- prometheus.MustNewConstMetric(c.requests, prometheus.GaugeValue, float64(s.Requests), name, getter)
+			ch <- prometheus.MustNewConstMetric(c.credits, prometheus.GaugeValue, s.Credits, name, getter)
+		}
+	}
+}
+
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, HasChallengeErr):
+		return "challenge"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	default:
+		return "error"
+	}
+}
+
+// Tracing starts a span around Get and Ping. A nil tracer uses the global
+// provider, so spans go wherever otel.SetTracerProvider points.
+func Tracing(tracer trace.Tracer) Middleware {
+	return func(next SourceGetter) SourceGetter {
+		return &tracedGetter{SourceGetter: next, tracer: tracer}
+	}
+}
+
+type tracedGetter struct {
+	SourceGetter
+	tracer trace.Tracer
+}
+
+func (t *tracedGetter) start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	tracer := t.tracer
+	if tracer == nil {
+		tracer = otel.Tracer(TracerName)
+	}
+	attrs = append(attrs, attribute.String("wp.getter", t.SourceGetter.Name()))
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+func (t *tracedGetter) Get(ctx context.Context, endpoint string, options ...SourceOptions) (*SourceResponse, error) {
+	ctx, span := t.start(ctx, "wp.Get", attribute.String("url.full", endpoint))
+	defer span.End()
+	resp, err := t.SourceGetter.Get(ctx, endpoint, options...)
+	if resp != nil {
+		span.SetAttributes(
+			attribute.Int("http.response.status_code", resp.StatusCode),
+			attribute.Int("wp.response.bytes", len(resp.Body)),
+			attribute.String("wp.served_by", resp.Getter),
+			attribute.Bool("wp.cached", resp.Cached),
+		)
+	}
+	var challengeErr *ChallengeError
+	if errors.As(err, &challengeErr) {
+		span.SetAttributes(
+			attribute.String("wp.challenge.vendor", string(challengeErr.Info.Vendor)),
+			attribute.String("wp.challenge.kind", string(challengeErr.Info.Kind)),
+		)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return resp, err
+}
+
+func (t *tracedGetter) Ping(ctx context.Context) HealthStatus {
+	ctx, span := t.start(ctx, "wp.Ping")
+	defer span.End()
+	status := t.SourceGetter.Ping(ctx)
+	span.SetAttributes(attribute.Bool("wp.healthy", status.Healthy))
+	if status.StatusCode > 0 {
+		span.SetAttributes(attribute.Int("http.response.status_code", status.StatusCode))
+	}
+	if status.Err != nil {
+		span.RecordError(status.Err)
+		span.SetStatus(codes.Error, status.Err.Error())
+	}
+	return status
+}
+
+func (t *tracedGetter) Unwrap() SourceGetter {
+	return t.SourceGetter
+}
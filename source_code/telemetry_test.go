@@ -0,0 +1,92 @@
+package source_code
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"testing"
+)
+
+func TestMetricsAndTracing(t *testing.T) {
+	ctx := context.Background()
+	metrics, err := NewMetrics(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)).Tracer(TracerName)
+
+	blocked := &stubGetter{name: "blocked", err: &ChallengeError{Info: ChallengeInfo{Vendor: VendorCloudflare, Kind: ChallengeKindJS}}}
+	ok := &stubGetter{name: "ok"}
+	f := NewFallback(
+		Chain(blocked, Tracing(tracer), metrics.Middleware()),
+		Chain(ok, Tracing(tracer), metrics.Middleware()),
+	)
+	f.Metrics = metrics
+	resp, err := Chain(f, Tracing(tracer)).Get(ctx, "https://example.com")
+	if err != nil || resp.Getter != "ok" {
+		t.Fatalf("unexpected result %v %v", resp, err)
+	}
+
+	if n := testutil.ToFloat64(metrics.Requests.WithLabelValues("ok", "200", "success")); n != 1 {
+		t.Fatalf("expected 1 successful request, got %v", n)
+	}
+	if n := testutil.ToFloat64(metrics.Requests.WithLabelValues("blocked", "403", "challenge")); n != 1 {
+		t.Fatalf("expected 1 challenged request, got %v", n)
+	}
+	if n := testutil.ToFloat64(metrics.Challenges.WithLabelValues("blocked", "cloudflare")); n != 1 {
+		t.Fatalf("expected 1 cloudflare challenge, got %v", n)
+	}
+	if n := testutil.ToFloat64(metrics.Escalations.WithLabelValues("blocked", "challenge")); n != 1 {
+		t.Fatalf("expected 1 escalation, got %v", n)
+	}
+	if n := testutil.ToFloat64(metrics.Bytes.WithLabelValues("ok")); n != 2 {
+		t.Fatalf("expected 2 bytes, got %v", n)
+	}
+	if n := testutil.CollectAndCount(metrics.Latency); n != 2 {
+		t.Fatalf("expected latency for 2 getters, got %d", n)
+	}
+
+	var disabled *Metrics
+	disabled.AddBudget(NewBudgeted(ok, 0, 0))
+	if _, err = Chain(ok, disabled.Middleware()).Get(ctx, "https://example.com"); err != nil {
+		t.Fatalf("expected a nil Metrics to pass requests through, got %v", err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 5 {
+		t.Fatalf("expected 2 pings and 3 gets, got %d spans", len(spans))
+	}
+	root := spans[len(spans)-1]
+	if root.Name != "wp.Get" || !hasAttribute(root.Attributes, attribute.String("wp.getter", "fallback")) ||
+		!hasAttribute(root.Attributes, attribute.String("wp.served_by", "ok")) {
+		t.Fatalf("unexpected root span %s %v", root.Name, root.Attributes)
+	}
+	var challenged bool
+	for _, span := range spans[:len(spans)-1] {
+		if span.Parent.SpanID() != root.SpanContext.SpanID() {
+			t.Fatalf("expected %s to be under the fallback span", span.Name)
+		}
+		if hasAttribute(span.Attributes, attribute.String("wp.challenge.vendor", "cloudflare")) {
+			challenged = span.Status.Code == codes.Error &&
+				hasAttribute(span.Attributes, attribute.Int("http.response.status_code", http.StatusForbidden))
+		}
+	}
+	if !challenged {
+		t.Fatalf("expected the blocked getter's span to record the challenge")
+	}
+}
+
+func hasAttribute(attrs []attribute.KeyValue, want attribute.KeyValue) bool {
+	for _, attr := range attrs {
+		if attr == want {
+			return true
+		}
+	}
+	return false
+}
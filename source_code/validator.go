@@ -0,0 +1,146 @@
+package source_code
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+	"regexp"
+	"strings"
+)
+
+var ValidationErr = errors.New("validation failed")
+
+// ValidationError is returned when a response fails a Validator. It matches both
+// ValidationErr and HasChallengeErr so it is handled like a soft block: not retried
+// and Fallback moves on to the next getter.
+type ValidationError struct {
+	Validator string
+	Reason    string
+}
+
+func (v *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", ValidationErr, v.Validator, v.Reason)
+}
+
+func (v *ValidationError) Unwrap() []error {
+	return []error{ValidationErr, HasChallengeErr}
+}
+
+type Validator interface {
+	Validate(resp *SourceResponse) error
+}
+
+type ValidatorFunc func(resp *SourceResponse) error
+
+func (v ValidatorFunc) Validate(resp *SourceResponse) error {
+	return v(resp)
+}
+
+func validate(resp *SourceResponse, validators []Validator) error {
+	for _, v := range validators {
+		if err := v.Validate(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func MinBodySize(size int) Validator {
+	return ValidatorFunc(func(resp *SourceResponse) error {
+		if len(resp.Body) < size {
+			return &ValidationError{Validator: "min-body-size", Reason: fmt.Sprintf("body is %d bytes, want at least %d", len(resp.Body), size)}
+		}
+		return nil
+	})
+}
+
+// RequireSelector fails responses whose HTML has no element matching the CSS selector.
+func RequireSelector(selector string) Validator {
+	sel, compileErr := cascadia.Compile(selector)
+	return ValidatorFunc(func(resp *SourceResponse) error {
+		if compileErr != nil {
+			return &ValidationError{Validator: "require-selector", Reason: fmt.Sprintf("invalid selector %q: %v", selector, compileErr)}
+		}
+		doc, err := html.Parse(bytes.NewReader(resp.Body))
+		if err != nil {
+			return &ValidationError{Validator: "require-selector", Reason: err.Error()}
+		}
+		if cascadia.Query(doc, sel) == nil {
+			return &ValidationError{Validator: "require-selector", Reason: fmt.Sprintf("no element matches %q", selector)}
+		}
+		return nil
+	})
+}
+
+func RequirePattern(pattern *regexp.Regexp) Validator {
+	return ValidatorFunc(func(resp *SourceResponse) error {
+		if !pattern.Match(resp.Body) {
+			return &ValidationError{Validator: "require-pattern", Reason: fmt.Sprintf("body does not match %q", pattern)}
+		}
+		return nil
+	})
+}
+
+func ForbidPattern(pattern *regexp.Regexp) Validator {
+	return ValidatorFunc(func(resp *SourceResponse) error {
+		if pattern.Match(resp.Body) {
+			return &ValidationError{Validator: "forbid-pattern", Reason: fmt.Sprintf("body matches %q", pattern)}
+		}
+		return nil
+	})
+}
+
+// ContentType fails responses whose media type is not one of mediaTypes, e.g. "text/html".
+func ContentType(mediaTypes ...string) Validator {
+	return ValidatorFunc(func(resp *SourceResponse) error {
+		mediaType := resp.MediaType()
+		for _, mt := range mediaTypes {
+			if strings.EqualFold(mt, mediaType) {
+				return nil
+			}
+		}
+		return &ValidationError{Validator: "content-type", Reason: fmt.Sprintf("got %q, want one of %v", mediaType, mediaTypes)}
+	})
+}
+
+// StatusRange fails responses whose status code is outside [min, max].
+func StatusRange(min, max int) Validator {
+	return ValidatorFunc(func(resp *SourceResponse) error {
+		if resp.StatusCode < min || resp.StatusCode > max {
+			return &ValidationError{Validator: "status-range", Reason: fmt.Sprintf("got %d, want %d-%d", resp.StatusCode, min, max)}
+		}
+		return nil
+	})
+}
+
+var _ SourceGetter = &Validated{}
+
+// Validated checks every successful Get of the wrapped getter against Validators,
+// whether or not the getter honours SourceOptions.Validators itself.
+type Validated struct {
+	SourceGetter
+	Validators []Validator
+}
+
+func NewValidated(getter SourceGetter, validators ...Validator) *Validated {
+	return &Validated{
+		SourceGetter: getter,
+		Validators:   validators,
+	}
+}
+
+func (v *Validated) Get(ctx context.Context, endpoint string, options ...SourceOptions) (*SourceResponse, error) {
+	resp, err := v.SourceGetter.Get(ctx, endpoint, options...)
+	if err != nil || resp == nil {
+		return resp, err
+	}
+	return resp, validate(resp, v.Validators)
+}
+
+// Unwrap returns the getter whose responses are validated.
+func (v *Validated) Unwrap() SourceGetter {
+	return v.SourceGetter
+}
@@ -0,0 +1,80 @@
+package source_code
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestValidators(t *testing.T) {
+	resp := &SourceResponse{
+		StatusCode: http.StatusOK,
+		Headers:    http.Header{"Content-Type": {"text/html; charset=utf-8"}},
+		Body:       []byte(`<html><body><h1 class="product-title">Widget</h1></body></html>`),
+	}
+	tests := []struct {
+		name      string
+		validator Validator
+		valid     bool
+	}{
+		{name: "min body size ok", validator: MinBodySize(10), valid: true},
+		{name: "min body size short", validator: MinBodySize(1000)},
+		{name: "selector found", validator: RequireSelector("h1.product-title"), valid: true},
+		{name: "selector missing", validator: RequireSelector(".price")},
+		{name: "selector invalid", validator: RequireSelector("h1[")},
+		{name: "pattern found", validator: RequirePattern(regexp.MustCompile(`Widget`)), valid: true},
+		{name: "forbidden pattern", validator: ForbidPattern(regexp.MustCompile(`(?i)sign in`)), valid: true},
+		{name: "forbidden pattern found", validator: ForbidPattern(regexp.MustCompile(`Widget`))},
+		{name: "content type", validator: ContentType("text/html"), valid: true},
+		{name: "content type mismatch", validator: ContentType("application/json")},
+		{name: "status range", validator: StatusRange(200, 299), valid: true},
+		{name: "status range mismatch", validator: StatusRange(300, 399)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.validator.Validate(resp)
+			if tt.valid && err != nil {
+				t.Fatalf("expected valid, got %v", err)
+			}
+			if !tt.valid && (!errors.Is(err, ValidationErr) || !errors.Is(err, HasChallengeErr)) {
+				t.Fatalf("expected validation error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestFallbackValidatorEscalation(t *testing.T) {
+	ctx := context.Background()
+	var hits int
+	shell := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		_, _ = w.Write([]byte(`<html><body><div id="app"></div></body></html>`))
+	}))
+	defer shell.Close()
+
+	d := NewDirect(shell.Client())
+	d.enabled.Store(true)
+	rendered := &stubGetter{name: "rendered"}
+	f := NewFallback(NewValidated(&enabledGetter{d}, RequireSelector("#app > *")), rendered)
+
+	resp, err := f.Get(ctx, shell.URL)
+	if err != nil || resp.Getter != "rendered" {
+		t.Fatalf("expected escalation past the empty shell, got %v %v", resp, err)
+	}
+	if hits != 1 {
+		t.Fatalf("expected direct to fetch the shell once, got %d", hits)
+	}
+}
+
+func TestValidatedChecksGettersIgnoringOptions(t *testing.T) {
+	// stubGetter never runs SourceOptions.Validators itself.
+	v := NewValidated(&stubGetter{name: "stub"}, MinBodySize(100))
+	_, err := v.Get(context.Background(), "https://example.com")
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Validator != "min-body-size" {
+		t.Fatalf("expected a min-body-size ValidationError, got %v", err)
+	}
+}
@@ -2,103 +2,274 @@ package source_code
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var _ SourceGetter = &ZenRows{}
 
 type ZenRows struct {
 	client   *http.Client
-	enabled  bool
+	enabled  atomic.Bool
 	apiKey   string
 	HostURL  string
 	JSRender bool
+	// Defaults are sent with every request and merged with SourceOptions.ZenRows.
+	Defaults ZenRowsOptions
+	// CostPerRequest is reported through Cost so CostAwareStrategy tries free getters first.
+	CostPerRequest float64
+	HealthCheck    HealthCheck
+
+	usageMu sync.Mutex
+	usage   ZenRowsUsage
+
+	flagsErr error
 }
 
+var DefaultZenRowsCost = 1.0
+
 func ZenRowFlags() *pflag.FlagSet {
 	fs := pflag.NewFlagSet("zenrows", pflag.ExitOnError)
 	fs.String("zenrows-api-key", "", "ZenRows API Key")
 	fs.Bool("zenrows-js-render", false, "Js Render to encode a Javascript")
 	fs.String("zenrows-host-url", "https://api.zenrows.com/v1/", "Host URL")
+	fs.Bool("zenrows-premium-proxy", false, "Use residential proxies")
+	fs.String("zenrows-proxy-country", "", "Country code for premium proxies")
+	fs.Duration("zenrows-wait", 0, "Wait after the page loads before returning")
+	fs.String("zenrows-wait-for", "", "CSS selector to wait for before returning")
+	fs.StringSlice("zenrows-block-resources", nil, "Resource types to block, such as image,font")
+	fs.String("zenrows-css-extractor", "", `Selectors to extract as JSON, such as {"title": "h1"}`)
+	fs.Bool("zenrows-autoparse", false, "Return ZenRows' parsed JSON instead of HTML")
+	fs.String("zenrows-js-instructions", "", `Browser steps as JSON, such as [{"click": ".more"}]`)
+	fs.Bool("zenrows-original-status", false, "Return the target's status code instead of ZenRows'")
+	fs.Int("zenrows-session-id", 0, "Session id to keep the same IP across requests")
+	fs.Bool("zenrows-screenshot", false, "Return a screenshot of the page instead of HTML")
+	fs.Bool("zenrows-custom-headers", false, "Forward request headers to the target")
+	fs.AddFlagSet(HealthCheckFlags("zenrows"))
 	return fs
 }
 
+// NewZenRowsFromFlags builds a ZenRows from the flags in ZenRowFlags. Flags that
+// fail to parse are reported by Err and keep the getter from being enabled.
 func NewZenRowsFromFlags(client *http.Client) *ZenRows {
-	return &ZenRows{
+	healthCheck, err := NewHealthCheckWithFlags("zenrows")
+	z := &ZenRows{
 		client:   client,
 		apiKey:   viper.GetString("zenrows-api-key"),
 		HostURL:  viper.GetString("zenrows-host-url"),
 		JSRender: viper.GetBool("zenrows-js-render"),
+		Defaults: ZenRowsOptions{
+			PremiumProxy:   Bool(viper.GetBool("zenrows-premium-proxy")),
+			ProxyCountry:   viper.GetString("zenrows-proxy-country"),
+			Wait:           viper.GetDuration("zenrows-wait"),
+			WaitFor:        viper.GetString("zenrows-wait-for"),
+			BlockResources: viper.GetStringSlice("zenrows-block-resources"),
+			Autoparse:      Bool(viper.GetBool("zenrows-autoparse")),
+			OriginalStatus: Bool(viper.GetBool("zenrows-original-status")),
+			SessionID:      viper.GetInt("zenrows-session-id"),
+			Screenshot:     Bool(viper.GetBool("zenrows-screenshot")),
+		},
+		CostPerRequest: DefaultZenRowsCost,
+		HealthCheck:    healthCheck,
+		flagsErr:       err,
+	}
+	// Left unset, request headers still turn custom_headers on.
+	if viper.GetBool("zenrows-custom-headers") {
+		z.Defaults.CustomHeaders = Bool(true)
 	}
+	if raw := viper.GetString("zenrows-css-extractor"); raw != "" && z.flagsErr == nil {
+		if err := json.Unmarshal([]byte(raw), &z.Defaults.CSSExtractor); err != nil {
+			z.flagsErr = fmt.Errorf("invalid zenrows-css-extractor: %w", err)
+		}
+	}
+	if raw := viper.GetString("zenrows-js-instructions"); raw != "" && z.flagsErr == nil {
+		if err := json.Unmarshal([]byte(raw), &z.Defaults.JSInstructions); err != nil {
+			z.flagsErr = fmt.Errorf("invalid zenrows-js-instructions: %w", err)
+		}
+	}
+	return z
+}
+
+// Err returns the error from parsing the flags NewZenRowsFromFlags read, nil when they were valid.
+func (z *ZenRows) Err() error {
+	return z.flagsErr
 }
 
 func NewZenRows(client *http.Client, apiKey string, JSRender bool) *ZenRows {
 	return &ZenRows{
-		client:   client,
-		apiKey:   apiKey,
-		HostURL:  "https://api.zenrows.com/v1/",
-		JSRender: JSRender,
+		client:         client,
+		apiKey:         apiKey,
+		HostURL:        "https://api.zenrows.com/v1/",
+		JSRender:       JSRender,
+		CostPerRequest: DefaultZenRowsCost,
 	}
 }
 
-func (z *ZenRows) Get(ctx context.Context, endpoint string, options ...SourceOptions) ([]byte, int, error) {
-	if !z.Enabled() {
-		return nil, http.StatusNotImplemented, fmt.Errorf("%s is not enabled", z.Name())
-	}
-	r, err := z.buildRequest(ctx, endpoint)
+func (z *ZenRows) Get(ctx context.Context, endpoint string, options ...SourceOptions) (*SourceResponse, error) {
+	o := mergeSourceOptions(options...)
+	return o.run(ctx, func(ctx context.Context) (*SourceResponse, error) {
+		return z.get(ctx, endpoint, o)
+	})
+}
+
+func (z *ZenRows) get(ctx context.Context, endpoint string, o SourceOptions) (*SourceResponse, error) {
+	sr := newSourceResponse(z.Name(), time.Now())
+	resp, err := z.do(ctx, sr, endpoint, o)
 	if err != nil {
-		z.enabled = false
-		return nil, http.StatusNotImplemented, err
+		return sr.done(), err
 	}
-	resp, err := z.client.Do(r)
-	if err != nil {
-		return nil, http.StatusNotImplemented, err
+	if err = sr.readBody(resp, o.MaxBodyBytes); err != nil {
+		return sr.done(), err
+	}
+	if strings.HasPrefix(sr.MediaType(), "image/") {
+		sr.Screenshot = sr.Body
+	}
+	if err = detectChallengeErr(sr.StatusCode, sr.Headers, sr.Body); err != nil {
+		return sr.done(), err
 	}
-	body, err := io.ReadAll(resp.Body)
+	return sr.done(), nil
+}
+
+// GetStream sends the request like Get but returns the body unread; the caller must close it.
+func (z *ZenRows) GetStream(ctx context.Context, endpoint string, options ...SourceOptions) (io.ReadCloser, *Meta, error) {
+	o := mergeSourceOptions(options...)
+	ctx, cancel := o.streamContext(ctx)
+	sr := newSourceResponse(z.Name(), time.Now())
+	resp, err := z.do(ctx, sr, endpoint, o)
 	if err != nil {
-		return nil, resp.StatusCode, err
+		cancel()
+		return nil, sr.meta(), err
 	}
-	return body, resp.StatusCode, nil
+	return streamBody(resp, o.MaxBodyBytes, cancel), sr.meta(), nil
 }
 
-func (z *ZenRows) Ping(ctx context.Context) bool {
-	r, err := z.buildRequest(ctx, PingURL)
+// do sends the request through ZenRows and fills sr from the response headers.
+func (z *ZenRows) do(ctx context.Context, sr *SourceResponse, endpoint string, o SourceOptions) (*http.Response, error) {
+	if !z.Enabled() {
+		sr.StatusCode = http.StatusNotImplemented
+		return nil, fmt.Errorf("%s is %w", z.Name(), NotEnabledErr)
+	}
+	r, err := z.buildRequest(ctx, endpoint, o)
 	if err != nil {
-		z.enabled = false
-		return false
+		// The call's options are at fault, not ZenRows, so the getter stays enabled.
+		sr.StatusCode = http.StatusNotImplemented
+		return nil, fmt.Errorf("%w: %w", InvalidRequestErr, err)
 	}
 	resp, err := z.client.Do(r)
 	if err != nil {
-		z.enabled = false
-		return z.enabled
+		sr.StatusCode = http.StatusNotImplemented
+		return nil, err
+	}
+	sr.fromHTTP(resp)
+	sr.FinalURL = endpoint
+	if finalURL := resp.Header.Get("Zr-Final-Url"); finalURL != "" {
+		sr.FinalURL = finalURL
 	}
-	z.enabled = resp.StatusCode >= http.StatusOK || resp.StatusCode < 300
-	return z.enabled
+	sr.Cost = z.recordUsage(resp.StatusCode, resp.Header)
+	return resp, nil
+}
 
+// Ping checks the free usage endpoint, or fetches HealthCheck.URL through ZenRows when it is set.
+func (z *ZenRows) Ping(ctx context.Context) HealthStatus {
+	if z.flagsErr != nil {
+		z.enabled.Store(false)
+		return HealthStatus{Getter: z.Name(), CheckedAt: time.Now(), Err: z.flagsErr}
+	}
+	status := z.HealthCheck.run(ctx, z.Name(), z.client, func(ctx context.Context) (*http.Request, error) {
+		if z.HealthCheck.URL != "" {
+			return z.buildRequest(ctx, z.HealthCheck.URL, SourceOptions{})
+		}
+		endpoint, err := url.JoinPath(z.HostURL, "usage")
+		if err != nil {
+			return nil, err
+		}
+		return http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+url.Values{"apikey": {z.apiKey}}.Encode(), nil)
+	}, nil)
+	z.enabled.Store(status.Healthy)
+	return status
 }
 
 func (z *ZenRows) Enabled() bool {
-	return z.enabled
+	return z.enabled.Load()
+}
+
+// HandlesChallenge is always true, ZenRows' anti-bot bypass covers every vendor we detect.
+func (z *ZenRows) HandlesChallenge(info ChallengeInfo) bool {
+	return true
+}
+
+func (z *ZenRows) Cost() float64 {
+	return z.CostPerRequest
+}
+
+// Usage returns the requests made, the cost ZenRows reported for them and the
+// concurrency figures from the latest response.
+func (z *ZenRows) Usage() ZenRowsUsage {
+	z.usageMu.Lock()
+	defer z.usageMu.Unlock()
+	return z.usage
+}
+
+// recordUsage reads X-Request-Cost and the concurrency headers, returning the
+// cost of this request. Without X-Request-Cost a 2xx costs CostPerRequest and
+// anything else nothing, since ZenRows does not bill its own 4xx and 5xx errors.
+func (z *ZenRows) recordUsage(statusCode int, header http.Header) float64 {
+	cost := 0.0
+	if v, err := strconv.ParseFloat(header.Get("X-Request-Cost"), 64); err == nil {
+		cost = v
+	} else if statusCode >= 200 && statusCode < 300 {
+		cost = z.CostPerRequest
+	}
+	z.usageMu.Lock()
+	defer z.usageMu.Unlock()
+	z.usage.Requests++
+	z.usage.Cost += cost
+	if v, err := strconv.Atoi(header.Get("Concurrency-Limit")); err == nil {
+		z.usage.ConcurrencyLimit = v
+	}
+	if v, err := strconv.Atoi(header.Get("Concurrency-Remaining")); err == nil {
+		z.usage.ConcurrencyRemaining = v
+	}
+	return cost
 }
 
 func (z *ZenRows) Name() string {
 	return "zen-rows"
 }
 
-func (z *ZenRows) buildRequest(ctx context.Context, endpoint string) (*http.Request, error) {
+func (z *ZenRows) buildRequest(ctx context.Context, endpoint string, o SourceOptions) (*http.Request, error) {
 	values := url.Values{}
 	values.Add("apiKey", z.apiKey)
 	values.Add("url", endpoint)
-	if z.JSRender {
-		values.Add("js_render", "true")
+	defaults := z.Defaults
+	if z.JSRender && defaults.JSRender == nil {
+		defaults.JSRender = Bool(true)
+	}
+	zo := mergeZenRowsOptions(&defaults, o.ZenRows)
+	// ZenRows only forwards request headers to the target when custom_headers is set.
+	if zo.CustomHeaders == nil && (len(o.Headers) > 0 || o.UserAgent != "" || len(o.Cookies) > 0) {
+		zo.CustomHeaders = Bool(true)
+	}
+	if err := zo.values(values); err != nil {
+		return nil, err
 	}
 
 	endpoint = fmt.Sprintf("%s?%s", z.HostURL, values.Encode())
 
-	return http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	r, err := http.NewRequestWithContext(ctx, o.method(), endpoint, o.body())
+	if err != nil {
+		return nil, err
+	}
+	o.apply(r)
+	return r, nil
 }
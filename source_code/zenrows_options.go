@@ -0,0 +1,153 @@
+package source_code
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ZenRowsOptions are the ZenRows API parameters. ZenRows.Defaults holds the ones
+// set by flags and SourceOptions.ZenRows overrides them per call. The switches
+// are pointers so a call can turn a default off with Bool(false); nil keeps the
+// default. Parameters that need a rendered page turn on js_render and
+// proxy_country turns on premium_proxy.
+type ZenRowsOptions struct {
+	JSRender       *bool
+	PremiumProxy   *bool
+	ProxyCountry   string
+	Wait           time.Duration
+	WaitFor        string
+	BlockResources []string
+	// CSSExtractor maps result names to selectors; the response becomes JSON.
+	CSSExtractor   map[string]string
+	Autoparse      *bool
+	JSInstructions []ZenRowsInstruction
+	// SessionID keeps the same IP for up to ten minutes across calls sharing it.
+	SessionID      int
+	OriginalStatus *bool
+	Screenshot     *bool
+	// CustomHeaders forwards request headers even when none are set on the call.
+	// Request headers turn it on unless it is set to false.
+	CustomHeaders *bool
+}
+
+// Bool returns a pointer to v for the switches in ZenRowsOptions.
+func Bool(v bool) *bool {
+	return &v
+}
+
+func isSet(b *bool) bool {
+	return b != nil && *b
+}
+
+// ZenRowsInstruction is a single js_instructions step such as {"click": ".more"} or {"wait": 500}.
+type ZenRowsInstruction map[string]any
+
+func mergeZenRowsOptions(base, o *ZenRowsOptions) *ZenRowsOptions {
+	if o == nil {
+		return base
+	}
+	merged := ZenRowsOptions{}
+	if base != nil {
+		merged = *base
+	}
+	for _, b := range []struct{ merged, o **bool }{
+		{&merged.JSRender, &o.JSRender},
+		{&merged.PremiumProxy, &o.PremiumProxy},
+		{&merged.Autoparse, &o.Autoparse},
+		{&merged.OriginalStatus, &o.OriginalStatus},
+		{&merged.Screenshot, &o.Screenshot},
+		{&merged.CustomHeaders, &o.CustomHeaders},
+	} {
+		if *b.o != nil {
+			*b.merged = *b.o
+		}
+	}
+	if o.ProxyCountry != "" {
+		merged.ProxyCountry = o.ProxyCountry
+	}
+	if o.Wait > 0 {
+		merged.Wait = o.Wait
+	}
+	if o.WaitFor != "" {
+		merged.WaitFor = o.WaitFor
+	}
+	if o.BlockResources != nil {
+		merged.BlockResources = o.BlockResources
+	}
+	if o.CSSExtractor != nil {
+		merged.CSSExtractor = o.CSSExtractor
+	}
+	if o.JSInstructions != nil {
+		merged.JSInstructions = o.JSInstructions
+	}
+	if o.SessionID > 0 {
+		merged.SessionID = o.SessionID
+	}
+	return &merged
+}
+
+func (o *ZenRowsOptions) values(values url.Values) error {
+	if o == nil {
+		return nil
+	}
+	jsRender := isSet(o.JSRender) || o.Wait > 0 || o.WaitFor != "" || len(o.JSInstructions) > 0 || isSet(o.Screenshot) || len(o.BlockResources) > 0
+	if jsRender {
+		values.Set("js_render", "true")
+	}
+	if isSet(o.PremiumProxy) || o.ProxyCountry != "" {
+		values.Set("premium_proxy", "true")
+	}
+	if o.ProxyCountry != "" {
+		values.Set("proxy_country", strings.ToLower(o.ProxyCountry))
+	}
+	if o.Wait > 0 {
+		values.Set("wait", strconv.FormatInt(o.Wait.Milliseconds(), 10))
+	}
+	if o.WaitFor != "" {
+		values.Set("wait_for", o.WaitFor)
+	}
+	if len(o.BlockResources) > 0 {
+		values.Set("block_resources", strings.Join(o.BlockResources, ","))
+	}
+	if len(o.CSSExtractor) > 0 {
+		b, err := json.Marshal(o.CSSExtractor)
+		if err != nil {
+			return err
+		}
+		values.Set("css_extractor", string(b))
+	}
+	if isSet(o.Autoparse) {
+		values.Set("autoparse", "true")
+	}
+	if len(o.JSInstructions) > 0 {
+		b, err := json.Marshal(o.JSInstructions)
+		if err != nil {
+			return err
+		}
+		values.Set("js_instructions", string(b))
+	}
+	if o.SessionID > 0 {
+		values.Set("session_id", strconv.Itoa(o.SessionID))
+	}
+	if isSet(o.OriginalStatus) {
+		values.Set("original_status", "true")
+	}
+	if isSet(o.Screenshot) {
+		values.Set("screenshot", "true")
+	}
+	if isSet(o.CustomHeaders) {
+		values.Set("custom_headers", "true")
+	}
+	return nil
+}
+
+// ZenRowsUsage is the spend and concurrency ZenRows reported on its responses.
+type ZenRowsUsage struct {
+	Requests             int64
+	Cost                 float64
+	ConcurrencyLimit     int
+	ConcurrencyRemaining int
+}
@@ -0,0 +1,165 @@
+package source_code
+
+import (
+	"context"
+	"errors"
+	"github.com/spf13/viper"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestZenRowsOptions(t *testing.T) {
+	ctx := context.Background()
+	var query map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Header().Set("Zr-Final-Url", "https://example.com/final")
+		w.Header().Set("X-Request-Cost", "25")
+		w.Header().Set("Concurrency-Limit", "10")
+		w.Header().Set("Concurrency-Remaining", "9")
+		_, _ = w.Write([]byte("<html></html>"))
+	}))
+	defer server.Close()
+
+	z := NewZenRows(server.Client(), "key", false)
+	z.HostURL = server.URL
+	z.enabled.Store(true)
+	z.Defaults = ZenRowsOptions{ProxyCountry: "US", BlockResources: []string{"image", "font"}}
+	resp, err := z.Get(ctx, "https://example.com/", SourceOptions{ZenRows: &ZenRowsOptions{
+		WaitFor:        ".content",
+		Wait:           1500 * time.Millisecond,
+		SessionID:      42,
+		OriginalStatus: Bool(true),
+		CSSExtractor:   map[string]string{"title": "h1"},
+		JSInstructions: []ZenRowsInstruction{{"click": ".more"}},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for key, want := range map[string]string{
+		"apikey":          "",
+		"apiKey":          "key",
+		"url":             "https://example.com/",
+		"js_render":       "true",
+		"premium_proxy":   "true",
+		"proxy_country":   "us",
+		"block_resources": "image,font",
+		"wait":            "1500",
+		"wait_for":        ".content",
+		"session_id":      "42",
+		"original_status": "true",
+		"css_extractor":   `{"title":"h1"}`,
+		"js_instructions": `[{"click":".more"}]`,
+		"custom_headers":  "",
+	} {
+		got := ""
+		if v := query[key]; len(v) > 0 {
+			got = v[0]
+		}
+		if got != want {
+			t.Errorf("%s: expected %q, got %q", key, want, got)
+		}
+	}
+	if resp.FinalURL != "https://example.com/final" || resp.Cost != 25 {
+		t.Fatalf("unexpected metadata %s %v", resp.FinalURL, resp.Cost)
+	}
+	if usage := z.Usage(); usage.Requests != 1 || usage.Cost != 25 || usage.ConcurrencyLimit != 10 || usage.ConcurrencyRemaining != 9 {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+
+	_, err = z.Get(ctx, "https://example.com/", SourceOptions{UserAgent: "wp"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if query["custom_headers"][0] != "true" || query["wait_for"] != nil {
+		t.Fatalf("per call options leaked or headers not forwarded: %v", query)
+	}
+}
+
+func TestZenRowsOptionsOff(t *testing.T) {
+	ctx := context.Background()
+	var query url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		_, _ = w.Write([]byte("<html></html>"))
+	}))
+	defer server.Close()
+
+	viper.Set("zenrows-host-url", server.URL)
+	viper.Set("zenrows-js-render", true)
+	viper.Set("zenrows-premium-proxy", true)
+	viper.Set("zenrows-autoparse", true)
+	viper.Set("zenrows-original-status", true)
+	viper.Set("zenrows-css-extractor", `{"title": "h1"}`)
+	viper.Set("zenrows-js-instructions", `[{"wait": 500}]`)
+	t.Cleanup(viper.Reset)
+	z := NewZenRowsFromFlags(server.Client())
+	if err := z.Err(); err != nil {
+		t.Fatal(err)
+	}
+	z.enabled.Store(true)
+
+	if _, err := z.Get(ctx, "https://example.com/"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if query.Get("premium_proxy") != "true" || query.Get("autoparse") != "true" || query.Get("original_status") != "true" ||
+		query.Get("css_extractor") != `{"title":"h1"}` || query.Get("js_instructions") != `[{"wait":500}]` {
+		t.Fatalf("expected the flag defaults, got %v", query)
+	}
+	_, err := z.Get(ctx, "https://example.com/", SourceOptions{UserAgent: "wp", ZenRows: &ZenRowsOptions{
+		PremiumProxy:   Bool(false),
+		Autoparse:      Bool(false),
+		OriginalStatus: Bool(false),
+		CustomHeaders:  Bool(false),
+		JSInstructions: []ZenRowsInstruction{},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, key := range []string{"premium_proxy", "autoparse", "original_status", "custom_headers"} {
+		if query.Has(key) {
+			t.Errorf("expected %s switched off for the call, got %v", key, query)
+		}
+	}
+
+	viper.Set("zenrows-css-extractor", "h1")
+	z = NewZenRowsFromFlags(server.Client())
+	if z.Err() == nil {
+		t.Fatal("expected invalid css extractor json to be rejected")
+	}
+	if status := z.Ping(ctx); status.Healthy || status.Err == nil || z.Enabled() {
+		t.Fatalf("expected invalid flags to keep ZenRows disabled, got %+v", status)
+	}
+}
+
+func TestZenRowsErrorsNotBilled(t *testing.T) {
+	ctx := context.Background()
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte("<html></html>"))
+	}))
+	defer server.Close()
+
+	z := NewZenRows(server.Client(), "key", false)
+	z.HostURL = server.URL
+	z.enabled.Store(true)
+	_, err := z.Get(ctx, "https://example.com/", SourceOptions{ZenRows: &ZenRowsOptions{
+		JSInstructions: []ZenRowsInstruction{{"click": func() {}}},
+	}})
+	if !errors.Is(err, InvalidRequestErr) || !z.Enabled() {
+		t.Fatalf("expected an invalid call to leave ZenRows enabled, got %v", err)
+	}
+
+	for _, status = range []int{http.StatusOK, http.StatusPaymentRequired, http.StatusUnprocessableEntity} {
+		if _, err = z.Get(ctx, "https://example.com/"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if usage := z.Usage(); usage.Requests != 3 || usage.Cost != DefaultZenRowsCost {
+		t.Fatalf("expected only the 200 billed, got %+v", usage)
+	}
+}